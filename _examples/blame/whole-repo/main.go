@@ -7,47 +7,41 @@ import (
 	"io"
 	"log"
 	"os"
-	"os/exec"
 
 	"github.com/mergestat/gitutils/blame"
-	"github.com/mergestat/gitutils/lstree"
 )
 
 func main() {
 	args := os.Args[1:]
 
-	iter, err := lstree.Exec(context.Background(), args[0], "HEAD", lstree.WithRecurse(true))
+	iter, err := blame.ExecRepo(context.Background(), args[0], blame.WithRevision("HEAD"))
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer iter.Close()
 
-	var objects []*lstree.Object
 	for {
-		if o, err := iter.Next(); err != nil {
+		res, err := iter.Next()
+		if err != nil {
 			if errors.Is(err, io.EOF) {
 				break
-			} else {
-				log.Fatal(err)
 			}
-		} else {
-			objects = append(objects, o)
+			log.Fatal(err)
 		}
-	}
 
-	for _, o := range objects {
-		if o.Type != "blob" {
-			continue
-		}
-		res, err := blame.Exec(context.Background(), args[0], o.Path)
-		if err != nil {
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				fmt.Println("Error", err, o.Path, string(exitErr.Stderr))
-			} else {
-				fmt.Println("Error", err)
+		if res.Err != nil {
+			var blameErr *blame.Error
+			switch {
+			case errors.Is(res.Err, blame.ErrNoSuchPath):
+				fmt.Println("Missing", res.Path)
+			case errors.As(res.Err, &blameErr):
+				fmt.Println("Error", res.Path, blameErr.Stderr)
+			default:
+				fmt.Println("Error", res.Path, res.Err)
 			}
 			continue
 		}
 
-		fmt.Println("Blamed", len(res))
+		fmt.Println("Blamed", res.Path, len(res.Result))
 	}
 }
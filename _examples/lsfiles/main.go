@@ -19,13 +19,13 @@ func main() {
 	}
 
 	for {
-		if file, err := iter.Next(); err != nil {
+		if entry, err := iter.Next(); err != nil {
 			if errors.Is(err, io.EOF) {
 				break
 			}
 			log.Fatal(err)
 		} else {
-			fmt.Println(file)
+			fmt.Println(entry.Path)
 		}
 	}
 
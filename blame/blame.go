@@ -2,13 +2,20 @@ package blame
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"os/exec"
+	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/mergestat/gitutils/internal/quote"
+	"github.com/mergestat/gitutils/mailmap"
+	"github.com/mergestat/gitutils/runner"
 )
 
 // Blame represents the blame of a particular line
@@ -22,15 +29,30 @@ type Blame struct {
 	Line           string
 	Summary        string
 	Boundary       bool
-	Previous       string // TODO(patrickdevivo) split the SHA and filename out
-	Filename       string
+	// Uncommitted is set for lines that aren't committed yet, which git attributes to the all-zero SHA
+	Uncommitted bool
+	// Previous is the raw "<sha> <path>" of the line's commit parent, as git reports it,
+	// see PreviousSHA and PreviousFilename for the parsed values
+	Previous         string
+	PreviousSHA      string
+	PreviousFilename string
+	Filename         string
+	// FromOtherFile is set when move or copy detection is enabled (see WithDetectMoves and WithDetectCopies)
+	// and the line was attributed to a different file than the one blamed, which also happens for
+	// lines that predate a rename of the file
+	FromOtherFile bool
 }
 
 // Event represents the who and when of a commit event
 type Event struct {
+	// Name and Email are the identity recorded in the commit
 	Name  string
 	Email string
-	When  time.Time
+	// CanonicalName and CanonicalEmail are the identity after applying the mailmap set with WithMailmap,
+	// they're the same as Name and Email otherwise
+	CanonicalName  string
+	CanonicalEmail string
+	When           time.Time
 }
 
 func (blame *Blame) String() string {
@@ -44,8 +66,157 @@ func (event *Event) String() string {
 // Result is a mapping of line numbers to blames for a given file
 type Result []*Blame
 
+// porcelain header keys, see here: https://git-scm.com/docs/git-blame#_the_porcelain_format
+const (
+	authorKey     = "author "
+	authorMailKey = "author-mail "
+	authorTimeKey = "author-time "
+	authorTZKey   = "author-tz "
+
+	committerKey     = "committer "
+	committerMailKey = "committer-mail "
+	committerTimeKey = "committer-time "
+	committerTZKey   = "committer-tz "
+
+	summaryKey  = "summary "
+	boundaryKey = "boundary"
+	previousKey = "previous "
+	filenameKey = "filename "
+	linePrefix  = "\t"
+)
+
+// parseHeaderLine applies a single porcelain header line (author, summary, filename...) to the blame.
+// It returns false if the line isn't one of the known header keys.
+func (blame *Blame) parseHeaderLine(line string) (bool, error) {
+	switch {
+	case strings.HasPrefix(line, authorKey):
+		blame.Author.Name = strings.TrimPrefix(line, authorKey)
+	case strings.HasPrefix(line, authorMailKey):
+		s := strings.TrimPrefix(line, authorMailKey)
+		blame.Author.Email = strings.Trim(s, "<>")
+	case strings.HasPrefix(line, authorTimeKey):
+		timeString := strings.TrimPrefix(line, authorTimeKey)
+		i, err := strconv.ParseInt(timeString, 10, 64)
+		if err != nil {
+			return true, err
+		}
+		blame.Author.When = time.Unix(i, 0)
+	case strings.HasPrefix(line, authorTZKey):
+		tzString := strings.TrimPrefix(line, authorTZKey)
+		parsed, err := time.Parse("-0700", tzString)
+		if err != nil {
+			return true, err
+		}
+		loc := parsed.Location()
+		blame.Author.When = blame.Author.When.In(loc)
+	case strings.HasPrefix(line, committerKey):
+		blame.Committer.Name = strings.TrimPrefix(line, committerKey)
+	case strings.HasPrefix(line, committerMailKey):
+		s := strings.TrimPrefix(line, committerMailKey)
+		blame.Committer.Email = strings.Trim(s, "<>")
+	case strings.HasPrefix(line, committerTimeKey):
+		timeString := strings.TrimPrefix(line, committerTimeKey)
+		i, err := strconv.ParseInt(timeString, 10, 64)
+		if err != nil {
+			return true, err
+		}
+		blame.Committer.When = time.Unix(i, 0)
+	case strings.HasPrefix(line, committerTZKey):
+		tzString := strings.TrimPrefix(line, committerTZKey)
+		parsed, err := time.Parse("-0700", tzString)
+		if err != nil {
+			return true, err
+		}
+		loc := parsed.Location()
+		blame.Committer.When = blame.Committer.When.In(loc)
+	case strings.HasPrefix(line, summaryKey):
+		blame.Summary = strings.TrimPrefix(line, summaryKey)
+	case strings.HasPrefix(line, boundaryKey):
+		blame.Boundary = true
+	case strings.HasPrefix(line, previousKey):
+		blame.Previous = strings.TrimPrefix(line, previousKey)
+		sha, filename, ok := strings.Cut(blame.Previous, " ")
+		if !ok {
+			return true, fmt.Errorf("invalid previous line in git blame output: %q", line)
+		}
+		unquoted, err := quote.Unquote(filename)
+		if err != nil {
+			return true, err
+		}
+		blame.PreviousSHA, blame.PreviousFilename = sha, unquoted
+	case strings.HasPrefix(line, filenameKey):
+		unquoted, err := quote.Unquote(strings.TrimPrefix(line, filenameKey))
+		if err != nil {
+			return true, err
+		}
+		blame.Filename = unquoted
+	default:
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// parseCommitHeader parses the line that starts each group of porcelain output,
+// "<sha> <original line> <final line> [<lines in group>]", into a new *Blame.
+// It returns false if the line doesn't look like a commit header.
+func parseCommitHeader(line string) (*Blame, bool, error) {
+	split := strings.Split(line, " ")
+	if !isHash(split[0]) { // if the first string sep by a space is a commit hash, it's the commit header
+		return nil, false, nil
+	}
+
+	blame := &Blame{
+		SHA:         split[0],
+		Uncommitted: strings.Trim(split[0], "0") == "",
+		Author:      &Event{},
+		Committer:   &Event{},
+	}
+
+	if len(split) < 3 {
+		return nil, true, fmt.Errorf("could not parse blame commit header: %q", line)
+	}
+
+	var err error
+	if blame.OriginalLineNo, err = strconv.Atoi(split[1]); err != nil {
+		return nil, true, err
+	}
+
+	if blame.FinalLineNo, err = strconv.Atoi(split[2]); err != nil {
+		return nil, true, err
+	}
+
+	if len(split) > 3 {
+		if blame.LinesInGroup, err = strconv.Atoi(split[3]); err != nil {
+			return nil, true, err
+		}
+	}
+
+	return blame, true, nil
+}
+
+// isHash returns true if s is a full SHA-1 (40 chars) or SHA-256 (64 chars) hex object name
+func isHash(s string) bool {
+	if len(s) != 40 && len(s) != 64 {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseLinePorcelain parses the output of git blame --line-porcelain or --porcelain. The latter only includes
+// the metadata of a commit the first time it appears, so o.commits must be set to parse it, and only repeats
+// the filename of a commit if it has lines from more than one file.
 func parseLinePorcelain(reader io.Reader, o *execOptions) (Result, error) {
-	scanner := bufio.NewScanner(reader)
+	nul := &nulReader{reader: reader}
+	scanner := bufio.NewScanner(nul)
 
 	if o.ScannerBuffer != nil {
 		scanner.Buffer(o.ScannerBuffer, o.ScannerBufferMax)
@@ -53,129 +224,133 @@ func parseLinePorcelain(reader io.Reader, o *execOptions) (Result, error) {
 
 	res := make(Result, 0)
 
-	const (
-		author     = "author "
-		authorMail = "author-mail "
-		authorTime = "author-time "
-		authorTZ   = "author-tz "
+	// filenames are only left out of the lines after the first of a group, which have the filename of the
+	// group, and of groups of commits with lines from a single file, which have the filename of the commit
+	files := make(map[string]*Blame) // the first blame of each commit
+	var group *Blame                 // the first blame of the current group
+	complete := func(blame *Blame, cached bool) *Blame {
+		if blame.LinesInGroup > 0 {
+			group = blame
+		}
 
-		committer     = "committer "
-		committerMail = "committer-mail "
-		committerTime = "committer-time "
-		committerTZ   = "committer-tz "
+		from, ok := files[blame.SHA]
+		if !ok {
+			files[blame.SHA] = blame
+		}
+		if group != nil && group != blame && group.SHA == blame.SHA {
+			from = group
+		}
 
-		summary    = "summary "
-		boundary   = "boundary"
-		previous   = "previous "
-		filename   = "filename "
-		linePrefix = "\t"
-	)
+		if blame.Filename == "" && from != nil {
+			blame.Filename = from.Filename
+			blame.Previous, blame.PreviousSHA, blame.PreviousFilename = from.Previous, from.PreviousSHA, from.PreviousFilename
+		}
+		return o.finish(blame, cached)
+	}
 
 	var currentBlame *Blame
+	var cached bool // whether the commit metadata of currentBlame came from o.commits
+	var binary bool // whether a line contained a NUL byte
 	for scanner.Scan() {
 		line := scanner.Text()
-		switch {
-		case strings.HasPrefix(line, author):
-			currentBlame.Author.Name = strings.TrimPrefix(line, author)
-		case strings.HasPrefix(line, authorMail):
-			s := strings.TrimPrefix(line, authorMail)
-			currentBlame.Author.Email = strings.Trim(s, "<>")
-		case strings.HasPrefix(line, authorTime):
-			timeString := strings.TrimPrefix(line, authorTime)
-			i, err := strconv.ParseInt(timeString, 10, 64)
-			if err != nil {
-				return nil, err
-			}
-			currentBlame.Author.When = time.Unix(i, 0)
-		case strings.HasPrefix(line, authorTZ):
-			tzString := strings.TrimPrefix(line, authorTZ)
-			parsed, err := time.Parse("-0700", tzString)
-			if err != nil {
-				return nil, err
-			}
-			loc := parsed.Location()
-			currentBlame.Author.When = currentBlame.Author.When.In(loc)
-		case strings.HasPrefix(line, committer):
-			currentBlame.Committer.Name = strings.TrimPrefix(line, committer)
-		case strings.HasPrefix(line, committerMail):
-			s := strings.TrimPrefix(line, committerMail)
-			currentBlame.Committer.Email = strings.Trim(s, "<>")
-		case strings.HasPrefix(line, committerTime):
-			timeString := strings.TrimPrefix(line, committerTime)
-			i, err := strconv.ParseInt(timeString, 10, 64)
-			if err != nil {
-				return nil, err
-			}
-			currentBlame.Committer.When = time.Unix(i, 0)
-		case strings.HasPrefix(line, committerTZ):
-			tzString := strings.TrimPrefix(line, committerTZ)
-			parsed, err := time.Parse("-0700", tzString)
-			if err != nil {
-				return nil, err
-			}
-			loc := parsed.Location()
-			currentBlame.Committer.When = currentBlame.Committer.When.In(loc)
-		case strings.HasPrefix(line, summary):
-			currentBlame.Summary = strings.TrimPrefix(line, summary)
-		case strings.HasPrefix(line, boundary):
-			currentBlame.Boundary = true
-		case strings.HasPrefix(line, previous):
-			currentBlame.Previous = strings.TrimPrefix(line, previous)
-		case strings.HasPrefix(line, filename):
-			currentBlame.Filename = strings.TrimPrefix(line, filename)
-		case strings.HasPrefix(line, linePrefix):
-			currentBlame.Line = strings.TrimPrefix(line, linePrefix)
-		case len(strings.Split(line, " ")[0]) == 40: // if the first string sep by a space is 40 chars long, it's probably the commit header
-			// there's an existing currentBlame, add it to the response
-			if currentBlame != nil {
-				res = append(res, currentBlame)
-			}
 
-			// reset the currentBlame to an empty struct, to be filled
-			currentBlame = &Blame{
-				Author:    &Event{},
-				Committer: &Event{},
+		if strings.HasPrefix(line, linePrefix) {
+			if currentBlame == nil {
+				return nil, fmt.Errorf("unexpected line in git blame output before a commit header: %q", line)
 			}
+			currentBlame.Line = strings.TrimPrefix(line, linePrefix)
+			binary = binary || strings.IndexByte(currentBlame.Line, 0) >= 0
+			continue
+		}
 
-			split := strings.Split(line, " ")
-			sha := split[0]
-			var err error
-
-			if currentBlame.OriginalLineNo, err = strconv.Atoi(split[1]); err != nil {
-				return nil, err
+		if currentBlame != nil {
+			if cached && isCommitMetadata(line) {
+				continue
 			}
-
-			if currentBlame.FinalLineNo, err = strconv.Atoi(split[2]); err != nil {
+			if ok, err := currentBlame.parseHeaderLine(line); err != nil {
 				return nil, err
+			} else if ok {
+				continue
 			}
+		}
 
-			if len(split) > 3 {
-				if currentBlame.LinesInGroup, err = strconv.Atoi(split[3]); err != nil {
-					return nil, err
-				}
+		blame, ok, err := parseCommitHeader(line)
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			if currentBlame == nil {
+				return nil, fmt.Errorf("unexpected line in git blame output before a commit header: %q", line)
 			}
+			continue
+		}
 
-			// set the SHA since we have it now
-			currentBlame.SHA = sha
+		// there's an existing currentBlame, add it to the response
+		if currentBlame != nil {
+			res = append(res, complete(currentBlame, cached))
 		}
+
+		currentBlame = blame
+		cached = o.commits.lookup(blame)
 	}
 	if err := scanner.Err(); err != nil {
+		// a binary file can have a line that's longer than the buffer, so look for a NUL in the rest of the output
+		if errors.Is(err, bufio.ErrTooLong) {
+			if _, copyErr := io.Copy(io.Discard, nul); copyErr == nil && nul.seen {
+				return nil, ErrBinaryFile
+			}
+		}
 		return nil, err
 	}
 
 	if currentBlame != nil {
-		res = append(res, currentBlame)
+		res = append(res, complete(currentBlame, cached))
+	}
+
+	if binary {
+		return nil, ErrBinaryFile
 	}
 
 	return res, nil
 }
 
+// nulReader records whether a NUL byte was read from reader
+type nulReader struct {
+	reader io.Reader
+	seen   bool
+}
+
+func (r *nulReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.seen = r.seen || bytes.IndexByte(p[:n], 0) >= 0
+	return n, err
+}
+
 type Option func(o *execOptions)
 
 type execOptions struct {
 	Revision         string
 	ScannerBuffer    []byte
 	ScannerBufferMax int
+	LineRanges       []string
+	DetectMoves      bool
+	MoveThreshold    int
+	DetectCopies     int
+	CopyThreshold    int
+	IgnoreWhitespace bool
+	IgnoreRevs       []string
+	IgnoreRevsFile   string
+	Reverse          bool
+	Contents         io.Reader
+	Porcelain        bool
+	Concurrency      int
+	Mailmap          *mailmap.Mailmap
+	Runner           *runner.Runner
+
+	// commits is shared by the blames of every file in ExecRepo
+	commits *commitCache
+	// prefix is the path of the repo path relative to the root of the repository, if it's already known
+	// (ExecRepo blames every file from the root), so that blamedPath doesn't have to ask git for it
+	prefix *string
 }
 
 func WithRevision(revision string) Option {
@@ -193,45 +368,268 @@ func WithScannerBuffer(buf []byte, max int) Option {
 	}
 }
 
-// Exec uses git to lookup the blame of a file, given the supplied options
+// WithLineRanges sets the -L <range> flag for each range, to only blame the given lines.
+// Ranges can be "<start>,<end>", "<start>,+<count>", ":<funcname>" or anything else git accepts.
+// See here: https://git-scm.com/docs/git-blame#Documentation/git-blame.txt--Lltstartgtltendgt
+func WithLineRanges(ranges []string) Option {
+	return func(o *execOptions) {
+		o.LineRanges = ranges
+	}
+}
+
+// WithDetectMoves sets the -M flag, to detect lines moved or copied within the file
+func WithDetectMoves(detectMoves bool) Option {
+	return func(o *execOptions) {
+		o.DetectMoves = detectMoves
+	}
+}
+
+// WithMoveThreshold sets the number of alphanumeric characters a moved line needs for -M to detect it (-M<num>)
+func WithMoveThreshold(n int) Option {
+	return func(o *execOptions) {
+		o.MoveThreshold = n
+	}
+}
+
+// WithDetectCopies sets the -C flag, to detect lines moved or copied from other files.
+// The level is the number of times -C is given (1 to 3), higher levels look harder for copies.
+// See here: https://git-scm.com/docs/git-blame#Documentation/git-blame.txt--Cltnumgt
+func WithDetectCopies(level int) Option {
+	return func(o *execOptions) {
+		o.DetectCopies = level
+	}
+}
+
+// WithCopyThreshold sets the number of alphanumeric characters a copied line needs for -C to detect it (-C<num>)
+func WithCopyThreshold(n int) Option {
+	return func(o *execOptions) {
+		o.CopyThreshold = n
+	}
+}
+
+// WithIgnoreWhitespace sets the -w flag
+func WithIgnoreWhitespace(ignoreWhitespace bool) Option {
+	return func(o *execOptions) {
+		o.IgnoreWhitespace = ignoreWhitespace
+	}
+}
+
+// WithIgnoreRevs sets the --ignore-rev <rev> flag for each revision, such as mass-formatting commits
+func WithIgnoreRevs(revs []string) Option {
+	return func(o *execOptions) {
+		o.IgnoreRevs = revs
+	}
+}
+
+// WithIgnoreRevsFile sets the --ignore-revs-file <file> flag
+func WithIgnoreRevsFile(file string) Option {
+	return func(o *execOptions) {
+		o.IgnoreRevsFile = file
+	}
+}
+
+// WithPorcelain makes Exec use the --porcelain format instead of --line-porcelain. The results are the same,
+// but git only outputs the metadata of each commit once, and lines of the same commit share the same
+// *Event values, which is faster and allocates less on large files.
+func WithPorcelain(porcelain bool) Option {
+	return func(o *execOptions) {
+		o.Porcelain = porcelain
+	}
+}
+
+// WithContents sets the --contents flag, to blame the content read from r instead of the file in the
+// working tree, such as the unsaved buffer of an editor. Lines that differ from the committed file are
+// attributed to the all-zero "Not Committed Yet" commit, see Blame.Uncommitted.
+func WithContents(r io.Reader) Option {
+	return func(o *execOptions) {
+		o.Contents = r
+	}
+}
+
+// WithReverse sets the --reverse flag, which walks history forward to find when lines were removed
+// rather than added. Use it with a revision range, such as WithRevision("v1.0..main").
+func WithReverse(reverse bool) Option {
+	return func(o *execOptions) {
+		o.Reverse = reverse
+	}
+}
+
+// WithConcurrency sets the maximum number of files ExecRepo blames at the same time,
+// which defaults to the number of CPUs
+func WithConcurrency(n int) Option {
+	return func(o *execOptions) {
+		o.Concurrency = n
+	}
+}
+
+// WithMailmap sets the mailmap used to resolve the canonical identities of authors and committers,
+// see mailmap.Load to read the mailmap of a repository
+func WithMailmap(m *mailmap.Mailmap) Option {
+	return func(o *execOptions) {
+		o.Mailmap = m
+	}
+}
+
+// WithRunner sets the *runner.Runner used to invoke git
+func WithRunner(r *runner.Runner) Option {
+	return func(o *execOptions) {
+		o.Runner = r
+	}
+}
+
+// finish fills in the canonical identities of a completely parsed blame and caches its
+// commit metadata, unless the metadata came from the cache already
+func (o *execOptions) finish(blame *Blame, cached bool) *Blame {
+	if cached {
+		return blame
+	}
+
+	for _, event := range []*Event{blame.Author, blame.Committer} {
+		event.CanonicalName, event.CanonicalEmail = o.Mailmap.Resolve(event.Name, event.Email)
+	}
+
+	o.commits.store(blame)
+	return blame
+}
+
+// flagArgsFromOptions returns a slice of flags from the given options struct
+func flagArgsFromOptions(o *execOptions) []string {
+	var args []string
+
+	for _, r := range o.LineRanges {
+		args = append(args, "-L", r)
+	}
+
+	if o.DetectMoves {
+		if o.MoveThreshold > 0 {
+			args = append(args, fmt.Sprintf("-M%d", o.MoveThreshold))
+		} else {
+			args = append(args, "-M")
+		}
+	}
+
+	for n := 0; n < o.DetectCopies && n < 3; n++ {
+		if n == 0 && o.CopyThreshold > 0 {
+			args = append(args, fmt.Sprintf("-C%d", o.CopyThreshold))
+		} else {
+			args = append(args, "-C")
+		}
+	}
+
+	if o.IgnoreWhitespace {
+		args = append(args, "-w")
+	}
+
+	for _, rev := range o.IgnoreRevs {
+		args = append(args, "--ignore-rev", rev)
+	}
+
+	if o.IgnoreRevsFile != "" {
+		args = append(args, "--ignore-revs-file", o.IgnoreRevsFile)
+	}
+
+	if o.Reverse {
+		args = append(args, "--reverse")
+	}
+
+	if o.Contents != nil {
+		args = append(args, "--contents", "-")
+	}
+
+	return args
+}
+
+// commandFromOptions returns the `git blame` command in the given output format
+func commandFromOptions(r *runner.Runner, repoPath, format, filePath string, o *execOptions) *runner.Command {
+	args := []string{"blame", format}
+	args = append(args, flagArgsFromOptions(o)...)
+	args = append(args, filePath)
+	if o.Revision != "" {
+		args = append(args, o.Revision)
+	}
+
+	c := r.Command(repoPath, args...)
+	c.Stdin = o.Contents
+	return c
+}
+
+// blamedPath returns the path of the blamed file relative to the root of the repository, as git
+// reports Filename, or an empty string when neither move nor copy detection is enabled
+func blamedPath(ctx context.Context, r *runner.Runner, repoPath, filePath string, o *execOptions) (string, error) {
+	if !o.DetectMoves && o.DetectCopies == 0 {
+		return "", nil
+	}
+
+	if o.prefix != nil {
+		return path.Clean(*o.prefix + filepath.ToSlash(filePath)), nil
+	}
+
+	out, err := r.Output(ctx, r.Command(repoPath, "rev-parse", "--show-prefix"))
+	if err != nil {
+		return "", err
+	}
+
+	return path.Clean(strings.TrimSpace(string(out)) + filepath.ToSlash(filePath)), nil
+}
+
+// Exec uses git to lookup the blame of a file, given the supplied options.
+// If blaming the file fails, the returned error is an *Error, see ErrNoSuchPath and the other errors it can match.
+// Its Kind is nil for failures that aren't recognized, such as output that can't be parsed.
 func Exec(ctx context.Context, repoPath, filePath string, options ...Option) (Result, error) {
 	o := &execOptions{}
 	for _, option := range options {
 		option(o)
 	}
 
-	gitPath, err := exec.LookPath("git")
-	if err != nil {
-		return nil, fmt.Errorf("could not find git: %w", err)
+	r := o.Runner
+	if r == nil {
+		r = runner.New()
 	}
 
-	args := []string{"blame", "--line-porcelain", filePath}
-	if o.Revision != "" {
-		args = append(args, o.Revision)
+	return execFile(ctx, r, repoPath, filePath, o)
+}
+
+// execFile runs git blame on a single file with options that have already been applied
+func execFile(ctx context.Context, r *runner.Runner, repoPath, filePath string, o *execOptions) (Result, error) {
+	format := "--line-porcelain"
+	if o.Porcelain {
+		format = "--porcelain"
+		// --porcelain only includes the metadata of a commit once, so it has to be cached
+		if o.commits == nil {
+			withCache := *o
+			withCache.commits = newCommitCache()
+			o = &withCache
+		}
 	}
-	cmd := exec.CommandContext(ctx, gitPath, args...)
-	cmd.Dir = repoPath
 
-	stdout, err := cmd.StdoutPipe()
+	proc, err := r.Start(ctx, commandFromOptions(r, repoPath, format, filePath, o))
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err, filePath, o)
 	}
 
-	if err := cmd.Start(); err != nil {
-		return nil, err
+	// the output of binary files is still read to the end, so that git exits normally
+	res, err := parseLinePorcelain(proc.Stdout(), o)
+	if err != nil && !errors.Is(err, ErrBinaryFile) {
+		_ = proc.Close()
+		return nil, wrapError(err, filePath, o)
+	}
+
+	if waitErr := proc.Wait(); waitErr != nil {
+		return nil, wrapError(waitErr, filePath, o)
 	}
 
-	res, err := parseLinePorcelain(stdout, o)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err, filePath, o)
 	}
 
-	if err := cmd.Wait(); err != nil {
-		// TODO report the error message (on stderr) if it exists?
-		// if exitErr, ok := err.(*exec.ExitError); ok {
-		// 	string(exitErr.Stderr)
-		// }
-		return nil, err
+	blamed, err := blamedPath(ctx, r, repoPath, filePath, o)
+	if err != nil {
+		return nil, wrapError(err, filePath, o)
+	}
+	if blamed != "" {
+		for _, blame := range res {
+			blame.FromOtherFile = blame.Filename != blamed
+		}
 	}
 
 	return res, nil
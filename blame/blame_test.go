@@ -3,15 +3,24 @@ package blame_test
 import (
 	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/mergestat/gitutils/blame"
+	"github.com/mergestat/gitutils/internal/testrepo"
+	"github.com/mergestat/gitutils/mailmap"
+	"github.com/mergestat/gitutils/runner"
 )
 
 var (
@@ -94,3 +103,838 @@ func TestBlameOutput(t *testing.T) {
 		t.Fatal("mismatch")
 	}
 }
+
+func newFixtureRepo(t testing.TB) string {
+	return testrepo.New(t, []testrepo.Commit{
+		{Files: map[string]string{"file.txt": "one\ntwo\nthree\n"}, Message: "initial commit"},
+		{Files: map[string]string{"file.txt": "one\n2\nthree\nfour\n"}, Message: "change two, add four"},
+	})
+}
+
+func TestFixtureBlame(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newFixtureRepo)
+
+	res, err := blame.Exec(context.Background(), path, "file.txt", blame.WithRunner(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		line    string
+		summary string
+	}{
+		{"one", "initial commit"},
+		{"2", "change two, add four"},
+		{"three", "initial commit"},
+		{"four", "change two, add four"},
+	}
+
+	if len(res) != len(want) {
+		t.Fatalf("got %d lines, want %d", len(res), len(want))
+	}
+
+	for i, b := range res {
+		if b.Line != want[i].line || b.Summary != want[i].summary {
+			t.Errorf("line %d: got %q (%s), want %q (%s)", i+1, b.Line, b.Summary, want[i].line, want[i].summary)
+		}
+
+		if b.FinalLineNo != i+1 {
+			t.Errorf("line %d: got final line number %d", i+1, b.FinalLineNo)
+		}
+
+		if b.Author.Name != "Fixture Author" || b.Author.Email != "author@example.com" {
+			t.Errorf("line %d: unexpected author: %s", i+1, b.Author)
+		}
+	}
+
+	if !res[0].Boundary || res[1].Boundary {
+		t.Errorf("expected only lines from the root commit to be boundary lines")
+	}
+}
+
+func TestFixtureIncremental(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newFixtureRepo)
+
+	want, err := blame.Exec(context.Background(), path, "file.txt", blame.WithRunner(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iter, err := blame.ExecIncremental(context.Background(), path, "file.txt", blame.WithRunner(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[int]*blame.Blame)
+	authors := make(map[string]*blame.Event)
+	for {
+		b, err := iter.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatal(err)
+		}
+
+		if a, ok := authors[b.SHA]; ok && a != b.Author {
+			t.Errorf("expected groups of the same commit to share the same *Event")
+		}
+		authors[b.SHA] = b.Author
+
+		for n := 0; n < b.LinesInGroup; n++ {
+			got[b.FinalLineNo+n] = b
+		}
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d lines, want %d", len(got), len(want))
+	}
+
+	for _, w := range want {
+		g := got[w.FinalLineNo]
+		if g.SHA != w.SHA || g.Summary != w.Summary || g.Boundary != w.Boundary || g.Filename != w.Filename || g.Previous != w.Previous {
+			t.Errorf("line %d: got %+v, want %+v", w.FinalLineNo, g, w)
+		}
+		if *g.Author != *w.Author || *g.Committer != *w.Committer {
+			t.Errorf("line %d: got %s %s, want %s %s", w.FinalLineNo, g.Author, g.Committer, w.Author, w.Committer)
+		}
+	}
+}
+
+func newCopyRepo(t testing.TB) string {
+	block := "func greet(name string) string {\n\treturn \"hello, \" + name + \", nice to meet you\"\n}\n"
+	return testrepo.New(t, []testrepo.Commit{
+		{Files: map[string]string{"src/a.go": block}, Message: "add greet"},
+		{Files: map[string]string{"src/b.go": "// copied from a.go\n" + block}, Message: "copy greet"},
+	})
+}
+
+// newMultiCopyRepo makes a commit with lines from two files, by copying both of them into a third one
+func newMultiCopyRepo(t testing.TB) string {
+	greet := "func greet(name string) string {\n\treturn \"hello, \" + name + \", nice to meet you\"\n}\n"
+	leave := "func leave(name string) string {\n\treturn \"goodbye, \" + name + \", see you soon\"\n}\n"
+	return testrepo.New(t, []testrepo.Commit{
+		{Files: map[string]string{"src/a.go": greet, "src/b.go": leave}, Message: "add greet and leave"},
+		{Files: map[string]string{"src/c.go": "// copied from a.go and b.go\n" + greet + "\n" + leave}, Message: "copy greet and leave"},
+	})
+}
+
+func TestFixtureCopyDetection(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newCopyRepo)
+
+	res, err := blame.Exec(context.Background(), path, "src/b.go", blame.WithRunner(r), blame.WithDetectCopies(2), blame.WithLineRanges([]string{"1,3"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != 3 {
+		t.Fatalf("got %d lines, want 3", len(res))
+	}
+
+	if res[0].Filename != "src/b.go" || res[0].FromOtherFile || res[0].Summary != "copy greet" {
+		t.Errorf("expected the first line to be attributed to src/b.go, got %+v", res[0])
+	}
+
+	if res[1].Filename != "src/a.go" || !res[1].FromOtherFile || res[1].Summary != "add greet" || res[1].OriginalLineNo != 1 {
+		t.Errorf("expected the second line to be copied from src/a.go, got %+v", res[1])
+	}
+
+	iter, err := blame.ExecIncremental(context.Background(), path, "src/b.go", blame.WithRunner(r), blame.WithDetectCopies(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := 0
+	for {
+		b, err := iter.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatal(err)
+		}
+
+		if b.FromOtherFile != (b.Filename == "src/a.go") {
+			t.Errorf("unexpected FromOtherFile for group at line %d: %+v", b.FinalLineNo, b)
+		}
+		lines += b.LinesInGroup
+	}
+
+	if lines != 4 {
+		t.Errorf("got %d lines, want 4", lines)
+	}
+}
+
+func newRenamedRepo(t testing.TB) string {
+	dir := testrepo.New(t, []testrepo.Commit{
+		{Files: map[string]string{"with space.txt": "one\n"}, Message: "add file"},
+	})
+	testrepo.Git(t, dir, 1, "mv", "with space.txt", `é "quoted".txt`)
+	testrepo.Git(t, dir, 1, "commit", "--quiet", "--message", "rename file")
+	if err := os.WriteFile(filepath.Join(dir, `é "quoted".txt`), []byte("one\ntwo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	testrepo.Git(t, dir, 2, "commit", "--quiet", "--all", "--message", "add two")
+	return dir
+}
+
+func TestFixturePrevious(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newRenamedRepo)
+
+	res, err := blame.Exec(context.Background(), path, `é "quoted".txt`, blame.WithRunner(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != 2 {
+		t.Fatalf("got %d lines, want 2", len(res))
+	}
+
+	if res[0].Filename != "with space.txt" || res[0].PreviousSHA != "" || res[0].PreviousFilename != "" {
+		t.Errorf("unexpected blame of the first line: %+v", res[0])
+	}
+
+	if res[1].Filename != `é "quoted".txt` || res[1].PreviousFilename != `é "quoted".txt` || len(res[1].PreviousSHA) != 40 {
+		t.Errorf("unexpected blame of the second line: %+v", res[1])
+	}
+
+	if !strings.HasPrefix(res[1].Previous, res[1].PreviousSHA+" ") {
+		t.Errorf("expected the raw previous line to start with the previous SHA, got %q", res[1].Previous)
+	}
+}
+
+func newSHA256Repo(t testing.TB) string {
+	return testrepo.New(t, []testrepo.Commit{
+		{Files: map[string]string{"sha256.txt": "one\ntwo\n"}, Message: "initial commit"},
+		{Files: map[string]string{"sha256.txt": "one\n2\n"}, Message: "change two"},
+	}, "--object-format=sha256")
+}
+
+func TestFixtureSHA256(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newSHA256Repo)
+
+	res, err := blame.Exec(context.Background(), path, "sha256.txt", blame.WithRunner(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != 2 {
+		t.Fatalf("got %d lines, want 2", len(res))
+	}
+
+	for i, b := range res {
+		if len(b.SHA) != 64 {
+			t.Errorf("line %d: expected a SHA-256 hash, got %q", i+1, b.SHA)
+		}
+	}
+
+	if res[0].Summary != "initial commit" || res[1].Summary != "change two" || len(res[1].PreviousSHA) != 64 {
+		t.Errorf("unexpected blame: %+v, %+v", res[0], res[1])
+	}
+
+	iter, err := blame.ExecIncremental(context.Background(), path, "sha256.txt", blame.WithRunner(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		b, err := iter.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatal(err)
+		}
+
+		if len(b.SHA) != 64 {
+			t.Errorf("expected a SHA-256 hash, got %q", b.SHA)
+		}
+	}
+}
+
+// outputExecutor is a runner.Executor that outputs the same string for every command
+type outputExecutor string
+
+func (e outputExecutor) Start(ctx context.Context, c *runner.Command) (runner.Process, error) {
+	return outputProcess{strings.NewReader(string(e))}, nil
+}
+
+type outputProcess struct{ io.Reader }
+
+func (p outputProcess) Stdout() io.Reader { return p.Reader }
+func (p outputProcess) Stderr() string    { return "" }
+func (p outputProcess) Wait() error       { return nil }
+func (p outputProcess) Close() error      { return nil }
+
+func TestUnexpectedOutput(t *testing.T) {
+	for _, output := range []string{
+		"\tcontent without a header\n",
+		"not a header\n",
+		"ABCDEF0123456789ABCDEF0123456789ABCDEF01 1 1 1\n",
+		"0123456789abcdef0123456789abcdef01234567 x 1 1\n",
+	} {
+		r := runner.New(runner.WithExecutor(outputExecutor(output)))
+		_, err := blame.Exec(context.Background(), ".", "file.txt", blame.WithRunner(r))
+		var blameErr *blame.Error
+		if !errors.As(err, &blameErr) || blameErr.Kind != nil || blameErr.Path != "file.txt" {
+			t.Errorf("expected an *Error with no Kind for output %q, got %v", output, err)
+		}
+
+		iter, err := blame.ExecIncremental(context.Background(), ".", "file.txt", blame.WithRunner(r))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = iter.Next()
+		if !errors.As(err, &blameErr) || blameErr.Kind != nil || blameErr.Path != "file.txt" {
+			t.Errorf("expected an *Error with no Kind for incremental output %q, got %v", output, err)
+		}
+	}
+}
+
+func newWholeRepo(t testing.TB) string {
+	dir := testrepo.New(t, []testrepo.Commit{
+		{Files: map[string]string{"README.md": "# readme\n", "src/main.go": "package main\n", "image.png": "\x89PNG\x00\x01\x02"}, Message: "initial commit"},
+		{Files: map[string]string{"src/main.go": "package main\n\nfunc main() {}\n", "docs/with space.md": "docs\n"}, Message: "add main"},
+	})
+	testrepo.Git(t, dir, 2, "update-index", "--add", "--cacheinfo", "160000,"+strings.Repeat("1", 40)+",vendor/submodule")
+	testrepo.Git(t, dir, 2, "commit", "--quiet", "--message", "add submodule")
+	return dir
+}
+
+func TestFixtureExecRepo(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newWholeRepo)
+
+	iter, err := blame.ExecRepo(context.Background(), path, blame.WithRunner(r), blame.WithConcurrency(2), blame.WithScannerBuffer(make([]byte, 4096), 1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+
+	got := make(map[string]blame.Result)
+	for {
+		res, err := iter.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatal(err)
+		}
+
+		if res.Err != nil {
+			t.Errorf("%s: %v", res.Path, res.Err)
+		}
+		got[res.Path] = res.Result
+	}
+
+	want := map[string]int{"README.md": 1, "src/main.go": 3, "docs/with space.md": 1}
+	if len(got) != len(want) {
+		t.Errorf("got files %v, want %v", got, want)
+	}
+
+	for p, lines := range want {
+		if len(got[p]) != lines {
+			t.Errorf("%s: got %d lines, want %d", p, len(got[p]), lines)
+		}
+	}
+
+	// README.md and the first line of src/main.go were added in the same commit
+	if readme, main := got["README.md"], got["src/main.go"]; len(readme) > 0 && len(main) > 0 && readme[0].Author != main[0].Author {
+		t.Errorf("expected blames of the same commit to share the same *Event across files")
+	}
+}
+
+// newSharedCommitRepo makes a single commit of more files than TestFixtureExecRepoSharedCommit uses workers
+func newSharedCommitRepo(t testing.TB) string {
+	files := make(map[string]string)
+	for n := 0; n < 16; n++ {
+		files[fmt.Sprintf("file%02d.txt", n)] = fmt.Sprintf("line of file %d\n", n)
+	}
+	return testrepo.New(t, []testrepo.Commit{{Files: files, Message: "add files"}})
+}
+
+// slowExecutor delays the output of git blame after its first line, so that the commit of files blamed
+// concurrently is looked up in the cache at the same time, and only stored after that
+type slowExecutor struct{ runner.Executor }
+
+func (e slowExecutor) Start(ctx context.Context, c *runner.Command) (runner.Process, error) {
+	p, err := e.Executor.Start(ctx, c)
+	if err != nil || c.Args[0] != "blame" {
+		return p, err
+	}
+	return slowProcess{p}, nil
+}
+
+type slowProcess struct{ runner.Process }
+
+func (p slowProcess) Stdout() io.Reader {
+	return &slowReader{reader: bufio.NewReader(p.Process.Stdout())}
+}
+
+type slowReader struct {
+	reader *bufio.Reader
+	reads  int
+}
+
+func (r *slowReader) Read(b []byte) (int, error) {
+	r.reads++
+	if r.reads == 1 {
+		line, err := r.reader.ReadSlice('\n')
+		return copy(b, line), err
+	}
+	if r.reads == 2 {
+		time.Sleep(20 * time.Millisecond)
+	}
+	return r.reader.Read(b)
+}
+
+func TestFixtureExecRepoSharedCommit(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newSharedCommitRepo)
+
+	// the workers blame files of the same commit at the same time, so they race to cache it
+	slow := runner.New(runner.WithExecutor(slowExecutor{r}))
+	iter, err := blame.ExecRepo(context.Background(), path, blame.WithRunner(slow), blame.WithRevision("main"), blame.WithConcurrency(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var author *blame.Event
+	var files int
+	for {
+		res, err := iter.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatal(err)
+		}
+
+		if res.Err != nil || len(res.Result) != 1 {
+			t.Fatalf("%s: unexpected result %v, %v", res.Path, res.Result, res.Err)
+		}
+
+		if author == nil {
+			author = res.Result[0].Author
+		}
+		if res.Result[0].Author != author {
+			t.Errorf("%s: expected blames of the same commit to share the same *Event across files", res.Path)
+		}
+		files++
+	}
+
+	if files != 16 {
+		t.Errorf("got %d files, want 16", files)
+	}
+}
+
+// prefixExecutor counts the git rev-parse --show-prefix processes it starts
+type prefixExecutor struct {
+	runner.Executor
+	started *int32
+}
+
+func (e prefixExecutor) Start(ctx context.Context, c *runner.Command) (runner.Process, error) {
+	if strings.Join(c.Args, " ") == "rev-parse --show-prefix" {
+		atomic.AddInt32(e.started, 1)
+	}
+	return e.Executor.Start(ctx, c)
+}
+
+func TestFixtureExecRepoCopyDetection(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newCopyRepo)
+
+	// every file is blamed from the root of the repository, so there's no need to ask git for the prefix
+	var started int32
+	counting := runner.New(runner.WithExecutor(prefixExecutor{Executor: r, started: &started}))
+	iter, err := blame.ExecRepo(context.Background(), path, blame.WithRunner(counting), blame.WithRevision("HEAD~0"), blame.WithDetectCopies(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+
+	got := make(map[string]blame.Result)
+	for {
+		res, err := iter.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatal(err)
+		}
+
+		if res.Err != nil {
+			t.Fatalf("%s: %v", res.Path, res.Err)
+		}
+		got[res.Path] = res.Result
+	}
+
+	if started := atomic.LoadInt32(&started); started != 0 {
+		t.Errorf("expected no git rev-parse --show-prefix, got %d", started)
+	}
+
+	for p, res := range got {
+		for _, b := range res {
+			if b.FromOtherFile != (b.Filename != p) {
+				t.Errorf("%s: unexpected FromOtherFile for line %d: %+v", p, b.FinalLineNo, b)
+			}
+		}
+	}
+
+	if b := got["src/b.go"]; len(b) != 4 || b[0].FromOtherFile || !b[1].FromOtherFile {
+		t.Errorf("expected the lines of src/b.go after the first to be copied from src/a.go, got %+v", b)
+	}
+}
+
+func TestFixtureExecRepoCancel(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newWholeRepo)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	iter, err := blame.ExecRepo(ctx, path, blame.WithRunner(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+
+	cancel()
+	for {
+		if _, err := iter.Next(); err != nil {
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("expected the context's error, got %v", err)
+			}
+			break
+		}
+	}
+}
+
+func TestOwnership(t *testing.T) {
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	alice := &blame.Event{Name: "Alice", Email: "alice@example.com", When: now.Add(-10 * 24 * time.Hour)}
+	aliceOld := &blame.Event{Name: "alice", Email: "Alice@Personal.dev", When: now.Add(-400 * 24 * time.Hour)}
+	bob := &blame.Event{Name: "Bob", Email: "bob@corp.example", When: now.Add(-100 * 24 * time.Hour)}
+
+	lines := func(authors ...*blame.Event) blame.Result {
+		var res blame.Result
+		for _, a := range authors {
+			res = append(res, &blame.Blame{Author: a, Committer: a})
+		}
+		return res
+	}
+
+	aggregator := blame.NewOwnershipAggregator(
+		blame.WithNow(now),
+		blame.WithIdentity(blame.EmailAliases(map[string]string{"alice@personal.dev": "alice@example.com"})),
+	)
+	aggregator.Add("src/a.go", lines(alice, alice, bob))
+	aggregator.Add("src/pkg/b.go", lines(aliceOld, bob))
+	aggregator.Add("README.md", lines(bob))
+	aggregator.AddError("image.png", errors.New("failed"))
+
+	report := aggregator.Report()
+
+	var paths []string
+	for _, f := range report.Files {
+		paths = append(paths, f.Path)
+	}
+	if want := "README.md src/a.go src/pkg/b.go"; strings.Join(paths, " ") != want {
+		t.Errorf("got files %v, want %s", paths, want)
+	}
+
+	paths = nil
+	for _, d := range report.Directories {
+		paths = append(paths, d.Path)
+	}
+	if want := ". src src/pkg"; strings.Join(paths, " ") != want {
+		t.Errorf("got directories %v, want %s", paths, want)
+	}
+
+	src := report.Directories[1]
+	if src.Lines != 5 {
+		t.Errorf("got %d lines in src, want 5", src.Lines)
+	}
+
+	wantAuthors := []blame.AuthorShare{
+		{Name: "Alice", Email: "alice@example.com", Lines: 3, Fraction: 0.6},
+		{Name: "Bob", Email: "bob@corp.example", Lines: 2, Fraction: 0.4},
+	}
+	if fmt.Sprint(src.Authors) != fmt.Sprint(wantAuthors) {
+		t.Errorf("got authors %v, want %v", src.Authors, wantAuthors)
+	}
+
+	wantDomains := []blame.Share{{Key: "example.com", Lines: 3, Fraction: 0.6}, {Key: "corp.example", Lines: 2, Fraction: 0.4}}
+	if fmt.Sprint(src.Domains) != fmt.Sprint(wantDomains) {
+		t.Errorf("got domains %v, want %v", src.Domains, wantDomains)
+	}
+
+	wantAges := []blame.Share{
+		{Key: "0d-30d", Lines: 2, Fraction: 0.4}, {Key: "30d-90d"}, {Key: "90d-180d", Lines: 2, Fraction: 0.4},
+		{Key: "180d-365d"}, {Key: "365d-730d", Lines: 1, Fraction: 0.2}, {Key: "730d+"},
+	}
+	if fmt.Sprint(src.Ages) != fmt.Sprint(wantAges) {
+		t.Errorf("got ages %v, want %v", src.Ages, wantAges)
+	}
+
+	if root := report.Directories[0]; root.Lines != 6 {
+		t.Errorf("got %d lines in the root directory, want 6", root.Lines)
+	}
+
+	if report.Errors["image.png"] != "failed" {
+		t.Errorf("expected the error of image.png to be reported, got %v", report.Errors)
+	}
+
+	if _, err := json.Marshal(report); err != nil {
+		t.Errorf("expected the report to be serializable: %v", err)
+	}
+}
+
+func TestFixtureExecOwnership(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newWholeRepo)
+
+	report, err := blame.ExecOwnership(context.Background(), path, blame.WithBlameOptions(blame.WithRunner(r)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Files) != 3 || len(report.Errors) != 0 {
+		t.Fatalf("got %d files and errors %v, want 3 files", len(report.Files), report.Errors)
+	}
+
+	root := report.Directories[0]
+	if root.Path != "." || root.Lines != 5 || len(root.Authors) != 1 || root.Authors[0].Email != "author@example.com" {
+		t.Errorf("unexpected ownership of the root directory: %+v", root)
+	}
+}
+
+func TestFixtureMailmap(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newFixtureRepo)
+
+	m, err := mailmap.Parse(strings.NewReader("Canonical Author <canonical@example.com> <author@example.com>\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := blame.Exec(context.Background(), path, "file.txt", blame.WithRunner(r), blame.WithMailmap(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, b := range res {
+		if b.Author.Name != "Fixture Author" || b.Author.Email != "author@example.com" {
+			t.Errorf("line %d: expected the raw author to be kept, got %s <%s>", i+1, b.Author.Name, b.Author.Email)
+		}
+		if b.Author.CanonicalName != "Canonical Author" || b.Author.CanonicalEmail != "canonical@example.com" {
+			t.Errorf("line %d: got canonical author %s <%s>", i+1, b.Author.CanonicalName, b.Author.CanonicalEmail)
+		}
+		if b.Committer.CanonicalName != "Fixture Committer" || b.Committer.CanonicalEmail != "committer@example.com" {
+			t.Errorf("line %d: expected the unmapped committer to be its own canonical identity, got %s <%s>", i+1, b.Committer.CanonicalName, b.Committer.CanonicalEmail)
+		}
+	}
+}
+
+func TestFixtureContents(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newFixtureRepo)
+
+	contents := strings.NewReader("one\n2\nedited\nfour\nfive\n")
+	res, err := blame.Exec(context.Background(), path, "file.txt", blame.WithRunner(r), blame.WithContents(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		line        string
+		uncommitted bool
+	}{
+		{"one", false},
+		{"2", false},
+		{"edited", true},
+		{"four", false},
+		{"five", true},
+	}
+
+	if len(res) != len(want) {
+		t.Fatalf("got %d lines, want %d", len(res), len(want))
+	}
+
+	for i, b := range res {
+		if b.Line != want[i].line || b.Uncommitted != want[i].uncommitted {
+			t.Errorf("line %d: got %q (uncommitted: %t), want %q (uncommitted: %t)", i+1, b.Line, b.Uncommitted, want[i].line, want[i].uncommitted)
+		}
+
+		if b.Uncommitted && b.SHA != strings.Repeat("0", 40) {
+			t.Errorf("line %d: expected the all-zero SHA for an uncommitted line, got %s", i+1, b.SHA)
+		}
+	}
+
+	// other contents get their own fixture
+	res, err = blame.Exec(context.Background(), path, "file.txt", blame.WithRunner(r), blame.WithContents(strings.NewReader("one\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].Line != "one" || res[0].Uncommitted {
+		t.Errorf("unexpected blame of other contents: %+v", res)
+	}
+}
+
+func TestFixturePorcelain(t *testing.T) {
+	tests := []struct {
+		name     string
+		build    func(testing.TB) string
+		filePath string
+		options  []blame.Option
+	}{
+		{"simple", newFixtureRepo, "file.txt", nil},
+		{"copies", newCopyRepo, "src/b.go", []blame.Option{blame.WithDetectCopies(2)}},
+		{"renamed", newRenamedRepo, `é "quoted".txt`, nil},
+		{"copies from two files", newMultiCopyRepo, "src/c.go", []blame.Option{blame.WithDetectCopies(2)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, path := testrepo.Fixtures(t, test.build)
+
+			options := append([]blame.Option{blame.WithRunner(r)}, test.options...)
+			want, err := blame.Exec(context.Background(), path, test.filePath, options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := blame.Exec(context.Background(), path, test.filePath, append(options, blame.WithPorcelain(true))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+
+			if test.name == "copies from two files" {
+				var files []string
+				for _, b := range got {
+					files = append(files, b.Filename)
+				}
+				want := "src/c.go src/a.go src/a.go src/a.go src/c.go src/b.go src/b.go src/b.go"
+				if strings.Join(files, " ") != want {
+					t.Errorf("got filenames %q, want %q", files, want)
+				}
+			}
+
+			for i := range got {
+				for j := range got[:i] {
+					if got[i].SHA == got[j].SHA && got[i].Author != got[j].Author {
+						t.Errorf("expected lines %d and %d of the same commit to share the same *Event", j+1, i+1)
+					}
+				}
+			}
+		})
+	}
+}
+
+// porcelainOutput generates the git blame output of a file with the given number of lines and commits,
+// in groups of 10 lines, in the --line-porcelain format or the --porcelain format
+func porcelainOutput(lines, commits int, linePorcelain bool) string {
+	var b strings.Builder
+	shown := make(map[int]bool)
+	for n := 0; n < lines; n++ {
+		commit := (n / 10) % commits
+		fmt.Fprintf(&b, "%040x %d %d", commit+1, n+1, n+1)
+		if n%10 == 0 {
+			fmt.Fprintf(&b, " %d", 10)
+		}
+		b.WriteString("\n")
+
+		if linePorcelain || (n%10 == 0 && !shown[commit]) {
+			fmt.Fprintf(&b, "author Author %d\nauthor-mail <author%d@example.com>\nauthor-time %d\nauthor-tz +0100\n", commit, commit, 1640000000+commit)
+			fmt.Fprintf(&b, "committer Committer %d\ncommitter-mail <committer%d@example.com>\ncommitter-time %d\ncommitter-tz +0100\n", commit, commit, 1640000000+commit)
+			fmt.Fprintf(&b, "summary commit number %d\nfilename file.txt\n", commit)
+			shown[commit] = true
+		}
+
+		fmt.Fprintf(&b, "\tthis is line number %d of the file\n", n+1)
+	}
+	return b.String()
+}
+
+func benchmarkExec(b *testing.B, linePorcelain bool, options ...blame.Option) {
+	r := runner.New(runner.WithExecutor(outputExecutor(porcelainOutput(10000, 50, linePorcelain))))
+	options = append(options, blame.WithRunner(r))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		res, err := blame.Exec(context.Background(), ".", "file.txt", options...)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(res) != 10000 {
+			b.Fatalf("got %d lines, want 10000", len(res))
+		}
+	}
+}
+
+func BenchmarkLinePorcelain(b *testing.B) {
+	benchmarkExec(b, true)
+}
+
+func BenchmarkPorcelain(b *testing.B) {
+	benchmarkExec(b, false, blame.WithPorcelain(true))
+}
+
+// newLongLineRepo commits a binary file with a line longer than the default scanner buffer, and the NUL at its end
+func newLongLineRepo(t testing.TB) string {
+	return testrepo.New(t, []testrepo.Commit{
+		{Files: map[string]string{"long.bin": strings.Repeat("a", bufio.MaxScanTokenSize) + "\x00\n"}, Message: "add long.bin"},
+	})
+}
+
+func TestFixtureErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		build    func(testing.TB) string
+		filePath string
+		options  []blame.Option
+		want     error
+	}{
+		{"no such path", newFixtureRepo, "missing.txt", []blame.Option{blame.WithRevision("HEAD")}, blame.ErrNoSuchPath},
+		{"bad revision", newFixtureRepo, "file.txt", []blame.Option{blame.WithRevision("does-not-exist")}, blame.ErrBadRevision},
+		{"not a repository", func(t testing.TB) string { return t.TempDir() }, "other.txt", nil, blame.ErrNotARepository},
+		{"binary file", newWholeRepo, "image.png", nil, blame.ErrBinaryFile},
+		{"binary file with a long line", newLongLineRepo, "long.bin", nil, blame.ErrBinaryFile},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, path := testrepo.Fixtures(t, test.build)
+
+			_, err := blame.Exec(context.Background(), path, test.filePath, append(test.options, blame.WithRunner(r))...)
+			if !errors.Is(err, test.want) {
+				t.Fatalf("got %v, want %v", err, test.want)
+			}
+
+			var blameErr *blame.Error
+			if !errors.As(err, &blameErr) || blameErr.Path != test.filePath {
+				t.Fatalf("expected a *blame.Error for %s, got %#v", test.filePath, err)
+			}
+
+			var exitErr *runner.ExitError
+			if test.want != blame.ErrBinaryFile && (!errors.As(err, &exitErr) || blameErr.Stderr == "" || blameErr.Stderr != exitErr.Stderr) {
+				t.Errorf("expected the stderr of git to be attached, got %#v", blameErr)
+			}
+
+			// --incremental output has no line contents, so binary files can't be detected from it
+			if test.want == blame.ErrBinaryFile {
+				return
+			}
+
+			iter, err := blame.ExecIncremental(context.Background(), path, test.filePath, append(test.options, blame.WithRunner(r))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer iter.Close()
+
+			for err == nil {
+				_, err = iter.Next()
+			}
+			if !errors.Is(err, test.want) || !errors.As(err, &blameErr) || blameErr.Path != test.filePath {
+				t.Errorf("expected a *blame.Error matching %v from the incremental iterator, got %#v", test.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package blame
+
+import (
+	"strings"
+	"sync"
+)
+
+// commitInfo is the metadata git blame reports for a commit, and which is shared
+// by every Blame attributed to that commit
+type commitInfo struct {
+	author    *Event
+	committer *Event
+	summary   string
+	boundary  bool
+}
+
+// commitCache holds the metadata of the commits seen so far, so that every Blame attributed to
+// the same commit shares the same *Event values. It's safe for concurrent use.
+type commitCache struct {
+	mu      sync.Mutex
+	commits map[string]*commitInfo
+}
+
+func newCommitCache() *commitCache {
+	return &commitCache{commits: make(map[string]*commitInfo)}
+}
+
+// lookup fills in the commit metadata of blame from the cache, and returns false if the commit isn't cached yet.
+// A nil *commitCache caches nothing.
+func (c *commitCache) lookup(blame *Blame) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	info, ok := c.commits[blame.SHA]
+	c.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	blame.Author = info.author
+	blame.Committer = info.committer
+	blame.Summary = info.summary
+	blame.Boundary = info.boundary
+
+	return true
+}
+
+// store caches the commit metadata of blame. If the commit was cached in the meantime (by another
+// file blamed concurrently), it fills in the commit metadata of blame from the cache instead.
+func (c *commitCache) store(blame *Blame) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if info, ok := c.commits[blame.SHA]; ok {
+		blame.Author = info.author
+		blame.Committer = info.committer
+		blame.Summary = info.summary
+		blame.Boundary = info.boundary
+		return
+	}
+
+	c.commits[blame.SHA] = &commitInfo{
+		author:    blame.Author,
+		committer: blame.Committer,
+		summary:   blame.Summary,
+		boundary:  blame.Boundary,
+	}
+}
+
+// isCommitMetadata returns true if line is a porcelain header line that describes the commit
+// rather than the line, which doesn't need to be parsed again once the commit is cached
+func isCommitMetadata(line string) bool {
+	return strings.HasPrefix(line, "author") || strings.HasPrefix(line, "committer") ||
+		strings.HasPrefix(line, summaryKey) || line == boundaryKey
+}
@@ -0,0 +1,91 @@
+package blame
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mergestat/gitutils/runner"
+)
+
+var (
+	// ErrNoSuchPath is returned when the file doesn't exist in the blamed revision (or the working tree)
+	ErrNoSuchPath = errors.New("no such path in revision")
+	// ErrBadRevision is returned when the revision can't be resolved
+	ErrBadRevision = errors.New("bad revision")
+	// ErrNotARepository is returned when the repo path isn't in a git repository
+	ErrNotARepository = errors.New("not a git repository")
+	// ErrBinaryFile is returned when the blamed file is binary, which Exec detects from NUL bytes in its output
+	ErrBinaryFile = errors.New("binary file")
+)
+
+// Error is returned when blaming a file fails. Use errors.Is to check its Kind against the
+// sentinel errors above, and errors.As to get the underlying *runner.ExitError if git failed.
+type Error struct {
+	// Kind is one of the sentinel errors of this package, or nil if the failure wasn't recognized
+	Kind     error
+	Path     string
+	Revision string
+	// Stderr is what git wrote to stderr, if it failed
+	Stderr string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	msg := strings.TrimPrefix(strings.TrimSpace(e.Stderr), "fatal: ")
+	switch {
+	case msg == "" && e.Kind != nil:
+		msg = e.Kind.Error()
+	case msg == "":
+		msg = e.Err.Error()
+	}
+
+	return fmt.Sprintf("blame %s: %s", e.Path, msg)
+}
+
+func (e *Error) Is(target error) bool { return e.Kind != nil && target == e.Kind }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// stderrKinds maps messages git writes to stderr to the sentinel errors
+var stderrKinds = []struct {
+	message string
+	kind    error
+}{
+	{"fatal: no such path", ErrNoSuchPath},
+	{"fatal: Cannot lstat", ErrNoSuchPath},
+	{"fatal: bad revision", ErrBadRevision},
+	{"fatal: Needed a single revision", ErrBadRevision},
+	{"fatal: not a git repository", ErrNotARepository},
+}
+
+// wrapError converts an error of git blame into an *Error, recognizing the failure from git's stderr.
+// Errors that aren't git exit failures (such as parse errors) are wrapped with a nil Kind.
+func wrapError(err error, filePath string, o *execOptions) error {
+	if err == nil {
+		return nil
+	}
+
+	e := &Error{Path: filePath, Revision: o.Revision, Err: err}
+	if errors.Is(err, ErrBinaryFile) {
+		e.Kind = ErrBinaryFile
+		return e
+	}
+
+	var exitErr *runner.ExitError
+	if !errors.As(err, &exitErr) {
+		return e
+	}
+
+	e.Stderr = exitErr.Stderr
+	for _, line := range strings.Split(exitErr.Stderr, "\n") {
+		for _, k := range stderrKinds {
+			if strings.HasPrefix(line, k.message) {
+				e.Kind = k.kind
+				return e
+			}
+		}
+	}
+
+	return e
+}
@@ -0,0 +1,135 @@
+package blame
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/mergestat/gitutils/runner"
+)
+
+type incrementalIterator struct {
+	scanner  *bufio.Scanner
+	proc     runner.Process
+	options  *execOptions
+	filePath string
+	blamed   string
+}
+
+// Next moves the iterator and returns the next group of lines (or error).
+// Iteration is complete when the error returned is io.EOF.
+//
+// Each *Blame describes LinesInGroup consecutive lines starting at FinalLineNo, and
+// groups are returned in the order git attributes them, not in line order.
+// The --incremental output doesn't include the content of lines, so Line is always empty.
+// Blames attributed to the same commit share the same Author and Committer *Event.
+// Failures are returned as an *Error, like those of Exec.
+func (i *incrementalIterator) Next() (*Blame, error) {
+	blame, err := i.next()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, wrapError(err, i.filePath, i.options)
+	}
+	return blame, err
+}
+
+func (i *incrementalIterator) next() (*Blame, error) {
+	var current *Blame
+	for i.scanner.Scan() {
+		line := i.scanner.Text()
+
+		if current == nil {
+			blame, ok, err := parseCommitHeader(line)
+			if err != nil {
+				_ = i.Close()
+				return nil, err
+			} else if !ok {
+				_ = i.Close()
+				return nil, fmt.Errorf("unexpected line in git blame --incremental output: %q", line)
+			}
+			current = blame
+			continue
+		}
+
+		if ok, err := current.parseHeaderLine(line); err != nil {
+			_ = i.Close()
+			return nil, err
+		} else if !ok {
+			_ = i.Close()
+			return nil, fmt.Errorf("unexpected line in git blame --incremental output: %q", line)
+		}
+
+		// the filename is always the last line of a group
+		if current.Filename != "" {
+			current.FromOtherFile = i.blamed != "" && current.Filename != i.blamed
+			return i.options.finish(current, i.options.commits.lookup(current)), nil
+		}
+	}
+
+	if err := i.scanner.Err(); err != nil {
+		_ = i.Close()
+		return nil, err
+	}
+
+	if err := i.proc.Wait(); err != nil {
+		return nil, err
+	}
+
+	if current != nil {
+		return nil, fmt.Errorf("unexpected end of git blame --incremental output: %w", io.ErrUnexpectedEOF)
+	}
+
+	return nil, io.EOF
+}
+
+// Close stops the underlying git process if it's still running and releases its resources.
+// It's safe to call Close more than once, and after iteration has completed.
+func (i *incrementalIterator) Close() error {
+	return i.proc.Close()
+}
+
+// ExecIncremental uses `git blame --incremental` to stream the blame of a file, given the supplied options.
+// Unlike Exec, which waits for git to finish before returning anything, the returned iterator
+// yields groups of lines as soon as git attributes them to a commit.
+// See here: https://git-scm.com/docs/git-blame#Documentation/git-blame.txt---incremental
+func ExecIncremental(ctx context.Context, repoPath, filePath string, options ...Option) (*incrementalIterator, error) {
+	o := &execOptions{}
+	for _, option := range options {
+		option(o)
+	}
+
+	r := o.Runner
+	if r == nil {
+		r = runner.New()
+	}
+
+	if o.commits == nil {
+		o.commits = newCommitCache()
+	}
+
+	blamed, err := blamedPath(ctx, r, repoPath, filePath, o)
+	if err != nil {
+		return nil, wrapError(err, filePath, o)
+	}
+
+	proc, err := r.Start(ctx, commandFromOptions(r, repoPath, "--incremental", filePath, o))
+	if err != nil {
+		return nil, wrapError(err, filePath, o)
+	}
+
+	scanner := bufio.NewScanner(proc.Stdout())
+	if o.ScannerBuffer != nil {
+		scanner.Buffer(o.ScannerBuffer, o.ScannerBufferMax)
+	}
+
+	iter := &incrementalIterator{
+		scanner:  scanner,
+		proc:     proc,
+		options:  o,
+		filePath: filePath,
+		blamed:   blamed,
+	}
+
+	return iter, nil
+}
@@ -0,0 +1,430 @@
+package blame
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IdentityFunc normalizes the name and email of an author, for instance by applying a mailmap
+// or mapping email aliases to a canonical address. Lines of authors it maps to the same email
+// (or the same name, for authors without an email) are counted together.
+type IdentityFunc func(name, email string) (string, string)
+
+// EmailAliases returns an IdentityFunc that replaces each alias email (the keys) with its
+// canonical email (the values). Emails are matched case-insensitively.
+func EmailAliases(aliases map[string]string) IdentityFunc {
+	lower := make(map[string]string, len(aliases))
+	for alias, canonical := range aliases {
+		lower[strings.ToLower(alias)] = canonical
+	}
+
+	return func(name, email string) (string, string) {
+		if canonical, ok := lower[strings.ToLower(email)]; ok {
+			return name, canonical
+		}
+		return name, email
+	}
+}
+
+// DefaultAgeBuckets are the upper bounds of the age buckets lines are counted in by default
+var DefaultAgeBuckets = []time.Duration{
+	30 * 24 * time.Hour,
+	90 * 24 * time.Hour,
+	180 * 24 * time.Hour,
+	365 * 24 * time.Hour,
+	730 * 24 * time.Hour,
+}
+
+// OwnershipReport is the ownership of every file blamed, and of every directory containing them
+type OwnershipReport struct {
+	// Files is sorted by path
+	Files []*OwnershipSummary
+	// Directories is sorted by path, the root of the repository is "."
+	Directories []*OwnershipSummary
+	// Errors maps the paths of the files that couldn't be blamed to the error message
+	Errors map[string]string
+}
+
+// OwnershipSummary is the share of the lines of a file or directory per author, email domain and age
+type OwnershipSummary struct {
+	Path  string
+	Lines int
+	// Authors is sorted by lines, in descending order
+	Authors []AuthorShare
+	// Domains is sorted by lines, in descending order
+	Domains []Share
+	// Ages has one entry per age bucket, from the most recent lines to the oldest
+	Ages []Share
+}
+
+// AuthorShare is the number of lines of an author, and their fraction of the total
+type AuthorShare struct {
+	Name     string
+	Email    string
+	Lines    int
+	Fraction float64
+}
+
+// Share is the number of lines of an email domain or an age bucket, and their fraction of the total
+type Share struct {
+	Key      string
+	Lines    int
+	Fraction float64
+}
+
+type OwnershipOption func(o *ownershipOptions)
+
+type ownershipOptions struct {
+	Identity     IdentityFunc
+	AgeBuckets   []time.Duration
+	Now          time.Time
+	BlameOptions []Option
+}
+
+// WithIdentity sets the IdentityFunc used to normalize the identity of authors
+func WithIdentity(identity IdentityFunc) OwnershipOption {
+	return func(o *ownershipOptions) {
+		o.Identity = identity
+	}
+}
+
+// WithAgeBuckets sets the upper bounds of the age buckets, in ascending order.
+// Lines older than the last bound are counted in a final bucket.
+func WithAgeBuckets(buckets []time.Duration) OwnershipOption {
+	return func(o *ownershipOptions) {
+		o.AgeBuckets = buckets
+	}
+}
+
+// WithNow sets the time the age of lines is measured from, which defaults to the current time
+func WithNow(now time.Time) OwnershipOption {
+	return func(o *ownershipOptions) {
+		o.Now = now
+	}
+}
+
+// WithBlameOptions sets the options ExecOwnership passes to ExecRepo
+func WithBlameOptions(options ...Option) OwnershipOption {
+	return func(o *ownershipOptions) {
+		o.BlameOptions = options
+	}
+}
+
+// tally counts the lines of a file or directory
+type tally struct {
+	lines   int
+	authors map[string]*authorTally
+	domains map[string]int
+	ages    []int
+}
+
+// authorTally counts the lines of an author under each of their names
+type authorTally struct {
+	email string
+	names map[string]int
+}
+
+func (a *authorTally) lines() int {
+	var lines int
+	for _, n := range a.names {
+		lines += n
+	}
+	return lines
+}
+
+// identity is the normalized identity of an author, and the keys their lines are counted under
+type identity struct {
+	key, name, email, domain string
+}
+
+func newTally(buckets int) *tally {
+	return &tally{
+		authors: make(map[string]*authorTally),
+		domains: make(map[string]int),
+		ages:    make([]int, buckets),
+	}
+}
+
+// add counts a single line of an author, in an age bucket
+func (t *tally) add(id identity, bucket int) {
+	t.lines++
+	t.author(id.key, id.email).names[id.name]++
+	t.domains[id.domain]++
+	t.ages[bucket]++
+}
+
+// author returns the tally of the author with the given key, creating it if needed
+func (t *tally) author(key, email string) *authorTally {
+	a, ok := t.authors[key]
+	if !ok {
+		a = &authorTally{email: email, names: make(map[string]int)}
+		t.authors[key] = a
+	}
+	return a
+}
+
+// merge adds the counts of other to t
+func (t *tally) merge(other *tally) {
+	t.lines += other.lines
+	for key, a := range other.authors {
+		merged := t.author(key, a.email)
+		for name, lines := range a.names {
+			merged.names[name] += lines
+		}
+	}
+	for domain, lines := range other.domains {
+		t.domains[domain] += lines
+	}
+	for bucket, lines := range other.ages {
+		t.ages[bucket] += lines
+	}
+}
+
+type ownershipAggregator struct {
+	mu      sync.Mutex
+	options *ownershipOptions
+	labels  []string
+	files   map[string]*tally
+	errors  map[string]string
+	// ids caches normalized identities by the name and email they're computed from
+	ids map[[2]string]identity
+}
+
+// NewOwnershipAggregator returns an aggregator that computes the ownership of the files
+// whose blames are added to it. It's safe for concurrent use.
+func NewOwnershipAggregator(options ...OwnershipOption) *ownershipAggregator {
+	o := &ownershipOptions{}
+	for _, option := range options {
+		option(o)
+	}
+
+	if o.Identity == nil {
+		o.Identity = func(name, email string) (string, string) { return name, email }
+	}
+
+	if o.AgeBuckets == nil {
+		o.AgeBuckets = DefaultAgeBuckets
+	}
+
+	if o.Now.IsZero() {
+		o.Now = time.Now()
+	}
+
+	return &ownershipAggregator{
+		options: o,
+		labels:  ageLabels(o.AgeBuckets),
+		files:   make(map[string]*tally),
+		errors:  make(map[string]string),
+		ids:     make(map[[2]string]identity),
+	}
+}
+
+// ageLabels returns the keys of the age buckets, such as "30d-90d" and "730d+"
+func ageLabels(buckets []time.Duration) []string {
+	days := func(d time.Duration) int { return int(d / (24 * time.Hour)) }
+
+	labels := make([]string, 0, len(buckets)+1)
+	var lower time.Duration
+	for _, upper := range buckets {
+		labels = append(labels, fmt.Sprintf("%dd-%dd", days(lower), days(upper)))
+		lower = upper
+	}
+
+	return append(labels, fmt.Sprintf("%dd+", days(lower)))
+}
+
+// identity returns the normalized identity of an author, caching it by name and email
+func (a *ownershipAggregator) identity(author *Event) identity {
+	name, email := author.Name, author.Email
+	if author.CanonicalName != "" || author.CanonicalEmail != "" {
+		name, email = author.CanonicalName, author.CanonicalEmail
+	}
+
+	key := [2]string{name, email}
+	if id, ok := a.ids[key]; ok {
+		return id
+	}
+
+	name, email = a.options.Identity(name, email)
+	id := identity{key: strings.ToLower(email), name: name, email: email}
+	if id.key == "" {
+		id.key = name
+	}
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		id.domain = strings.ToLower(email[at+1:])
+	}
+
+	a.ids[key] = id
+	return id
+}
+
+// bucket returns the index of the age bucket of a line last changed at when
+func (a *ownershipAggregator) bucket(when time.Time) int {
+	age := a.options.Now.Sub(when)
+	for n, upper := range a.options.AgeBuckets {
+		if age < upper {
+			return n
+		}
+	}
+	return len(a.options.AgeBuckets)
+}
+
+// Add counts the lines of the blame of a file, as returned by Exec (each *Blame is a single line).
+// Authors are identified by their canonical identity (see WithMailmap), and the age of a line
+// is measured from its author date.
+func (a *ownershipAggregator) Add(filePath string, res Result) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	t := newTally(len(a.labels))
+	for _, blame := range res {
+		id := a.identity(blame.Author)
+		t.add(id, a.bucket(blame.Author.When))
+	}
+
+	a.files[filePath] = t
+}
+
+// AddError records that a file couldn't be blamed
+func (a *ownershipAggregator) AddError(filePath string, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.errors[filePath] = err.Error()
+}
+
+// Report returns the ownership of the files added so far, and of the directories containing them
+func (a *ownershipAggregator) Report() *OwnershipReport {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	dirs := make(map[string]*tally)
+	report := &OwnershipReport{Errors: make(map[string]string, len(a.errors))}
+
+	for filePath, t := range a.files {
+		report.Files = append(report.Files, a.summary(filePath, t))
+
+		for dir := path.Dir(filePath); ; dir = path.Dir(dir) {
+			d, ok := dirs[dir]
+			if !ok {
+				d = newTally(len(a.labels))
+				dirs[dir] = d
+			}
+			d.merge(t)
+
+			if dir == "." || dir == "/" {
+				break
+			}
+		}
+	}
+
+	for dir, t := range dirs {
+		report.Directories = append(report.Directories, a.summary(dir, t))
+	}
+
+	for filePath, err := range a.errors {
+		report.Errors[filePath] = err
+	}
+
+	sort.Slice(report.Files, func(i, j int) bool { return report.Files[i].Path < report.Files[j].Path })
+	sort.Slice(report.Directories, func(i, j int) bool { return report.Directories[i].Path < report.Directories[j].Path })
+
+	return report
+}
+
+// summary converts a tally into an *OwnershipSummary
+func (a *ownershipAggregator) summary(p string, t *tally) *OwnershipSummary {
+	fraction := func(lines int) float64 {
+		if t.lines == 0 {
+			return 0
+		}
+		return float64(lines) / float64(t.lines)
+	}
+
+	s := &OwnershipSummary{Path: p, Lines: t.lines}
+
+	for _, author := range t.authors {
+		lines := author.lines()
+		s.Authors = append(s.Authors, AuthorShare{
+			Name:     mostCommonName(author.names),
+			Email:    author.email,
+			Lines:    lines,
+			Fraction: fraction(lines),
+		})
+	}
+	sort.Slice(s.Authors, func(i, j int) bool {
+		if s.Authors[i].Lines != s.Authors[j].Lines {
+			return s.Authors[i].Lines > s.Authors[j].Lines
+		}
+		return s.Authors[i].Email+s.Authors[i].Name < s.Authors[j].Email+s.Authors[j].Name
+	})
+
+	for domain, lines := range t.domains {
+		s.Domains = append(s.Domains, Share{Key: domain, Lines: lines, Fraction: fraction(lines)})
+	}
+	sort.Slice(s.Domains, func(i, j int) bool {
+		if s.Domains[i].Lines != s.Domains[j].Lines {
+			return s.Domains[i].Lines > s.Domains[j].Lines
+		}
+		return s.Domains[i].Key < s.Domains[j].Key
+	})
+
+	for n, lines := range t.ages {
+		s.Ages = append(s.Ages, Share{Key: a.labels[n], Lines: lines, Fraction: fraction(lines)})
+	}
+
+	return s
+}
+
+// mostCommonName returns the name with the most lines, or the first one alphabetically in case of a tie
+func mostCommonName(names map[string]int) string {
+	var name string
+	var max int
+	for n, lines := range names {
+		if lines > max || (lines == max && n < name) {
+			name, max = n, lines
+		}
+	}
+	return name
+}
+
+// ExecOwnership blames every file in a repository with ExecRepo, and returns the ownership report
+func ExecOwnership(ctx context.Context, repoPath string, options ...OwnershipOption) (*OwnershipReport, error) {
+	o := &ownershipOptions{}
+	for _, option := range options {
+		option(o)
+	}
+
+	iter, err := ExecRepo(ctx, repoPath, o.BlameOptions...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = iter.Close() }()
+
+	aggregator := NewOwnershipAggregator(options...)
+	for {
+		res, err := iter.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+
+		if res.Err != nil {
+			aggregator.AddError(res.Path, res.Err)
+			continue
+		}
+
+		aggregator.Add(res.Path, res.Result)
+	}
+
+	return aggregator.Report(), nil
+}
@@ -0,0 +1,222 @@
+package blame
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/mergestat/gitutils/lstree"
+	"github.com/mergestat/gitutils/runner"
+)
+
+// FileResult is the blame of a single file in a repository, or the error that occurred blaming it
+type FileResult struct {
+	Path   string
+	Result Result
+	Err    error
+}
+
+type repoIterator struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	results chan *FileResult
+	wg      sync.WaitGroup
+}
+
+// Next returns the blame of the next file (or error), in the order the files finish being blamed.
+// An error blaming a single file is reported in FileResult.Err and doesn't stop the iteration.
+// Iteration is complete when the error returned is io.EOF, or the context's error if it's cancelled.
+func (i *repoIterator) Next() (*FileResult, error) {
+	res, ok := <-i.results
+	if !ok {
+		err := i.ctx.Err()
+		// every worker is done, release the context even if Close isn't called
+		i.cancel()
+		if err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+
+	return res, nil
+}
+
+// Close stops blaming files and waits for the running git processes to exit.
+// It's safe to call Close more than once, and after iteration has completed.
+func (i *repoIterator) Close() error {
+	i.cancel()
+	for range i.results {
+	}
+	i.wg.Wait()
+	return nil
+}
+
+// ExecRepo blames every file in a repository at the revision set with WithRevision (HEAD by default),
+// running up to WithConcurrency git processes at a time. Binary files and submodules are skipped.
+// The other options apply to the blame of each file, and blames of the same commit share the
+// same *Event values across files.
+func ExecRepo(ctx context.Context, repoPath string, options ...Option) (*repoIterator, error) {
+	o := &execOptions{}
+	for _, option := range options {
+		option(o)
+	}
+
+	r := o.Runner
+	if r == nil {
+		r = runner.New()
+	}
+
+	concurrency := o.Concurrency
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+
+	revision := o.Revision
+	if revision == "" {
+		revision = "HEAD"
+	}
+
+	// pin the revision to a commit, so that every file is blamed at the same one
+	out, err := r.Output(ctx, r.Command(repoPath, "rev-parse", "--show-cdup", "--verify", revision+"^{commit}"))
+	if err != nil {
+		return nil, wrapError(err, repoPath, o)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	if len(lines) != 2 {
+		return nil, fmt.Errorf("unexpected git rev-parse output: %q", out)
+	}
+
+	// paths are listed relative to the root of the repository, so run everything from there
+	root, commit := filepath.Join(repoPath, lines[0]), lines[1]
+
+	paths, err := listFiles(ctx, r, root, commit)
+	if err != nil {
+		return nil, err
+	}
+
+	fileOptions := *o
+	fileOptions.Revision = commit
+	fileOptions.Contents = nil
+	fileOptions.commits = newCommitCache()
+	fileOptions.prefix = new(string) // paths are relative to the root
+
+	ctx, cancel := context.WithCancel(ctx)
+	iter := &repoIterator{
+		ctx:     ctx,
+		cancel:  cancel,
+		results: make(chan *FileResult),
+	}
+
+	queue := make(chan string)
+	go func() {
+		defer close(queue)
+		for _, p := range paths {
+			select {
+			case queue <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	iter.wg.Add(concurrency)
+	for n := 0; n < concurrency; n++ {
+		go func() {
+			defer iter.wg.Done()
+
+			// scanners can't share a buffer, so give each worker its own
+			workerOptions := fileOptions
+			if fileOptions.ScannerBuffer != nil {
+				workerOptions.ScannerBuffer = make([]byte, len(fileOptions.ScannerBuffer))
+			}
+
+			for p := range queue {
+				res, err := execFile(ctx, r, root, p, &workerOptions)
+				if ctx.Err() != nil {
+					return
+				}
+
+				select {
+				case iter.results <- &FileResult{Path: p, Result: res, Err: err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		iter.wg.Wait()
+		close(iter.results)
+	}()
+
+	return iter, nil
+}
+
+// listFiles returns the paths of the files in commit that can be blamed, skipping binary files and submodules
+func listFiles(ctx context.Context, r *runner.Runner, root, commit string) ([]string, error) {
+	binaries, err := binaryFiles(ctx, r, root, commit)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := lstree.Exec(ctx, root, commit, lstree.WithRecurse(true), lstree.WithRunner(r))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = iter.Close() }()
+
+	var paths []string
+	for {
+		object, err := iter.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+
+		// submodules are listed as commit objects
+		if object.Type != lstree.BlobType {
+			continue
+		}
+
+		if !binaries[object.Path] {
+			paths = append(paths, object.Path)
+		}
+	}
+
+	return paths, nil
+}
+
+// binaryFiles returns the set of paths in commit that git considers binary, by diffing it against the empty tree
+func binaryFiles(ctx context.Context, r *runner.Runner, root, commit string) (map[string]bool, error) {
+	// the hash of the empty tree depends on the object format of the repo
+	c := r.Command(root, "hash-object", "-t", "tree", "--stdin")
+	c.Stdin = strings.NewReader("")
+	emptyTree, err := r.Output(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := r.Output(ctx, r.Command(root, "diff-tree", "-r", "--numstat", "-z", "--no-renames", strings.TrimSpace(string(emptyTree)), commit))
+	if err != nil {
+		return nil, err
+	}
+
+	// binary files have "-" for both the added and deleted line counts
+	binaries := make(map[string]bool)
+	for _, entry := range strings.Split(string(out), "\x00") {
+		if p := strings.TrimPrefix(entry, "-\t-\t"); p != entry {
+			binaries[p] = true
+		}
+	}
+
+	return binaries, nil
+}
@@ -2,8 +2,11 @@ package clone
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os/exec"
+
+	"github.com/mergestat/gitutils/runner"
 )
 
 type execOptions struct {
@@ -43,6 +46,7 @@ type execOptions struct {
 	Depth                int
 	Jobs                 int
 	Config               []ConfigKV
+	Runner               *runner.Runner
 }
 
 type ConfigKV struct {
@@ -304,6 +308,13 @@ func WithServerOptions(ServerOptions []string) Option {
 	}
 }
 
+// WithRunner sets the *runner.Runner used to invoke git
+func WithRunner(r *runner.Runner) Option {
+	return func(o *execOptions) {
+		o.Runner = r
+	}
+}
+
 // flagArgsFromOptions returns a slice of flags from the given options struct
 func flagArgsFromOptions(o *execOptions) []string {
 	var args []string
@@ -463,23 +474,32 @@ func flagArgsFromOptions(o *execOptions) []string {
 }
 
 // Exec runs `git clone` using the os/exec standard library package.
+// If git exits with a non-zero status, the returned error is an *exec.ExitError with Stderr populated.
+// When the run is replayed from a fixture (see runner.Replayer), no process ran, so the error is the
+// *runner.ExitError instead, which has the recorded exit code and stderr and still wraps an *exec.ExitError.
 func Exec(ctx context.Context, repo, dir string, options ...Option) error {
 	o := &execOptions{}
 	for _, option := range options {
 		option(o)
 	}
 
-	gitPath, err := exec.LookPath("git")
-	if err != nil {
-		return fmt.Errorf("could not find git: %w", err)
+	r := o.Runner
+	if r == nil {
+		r = runner.New()
 	}
 
 	args := []string{"clone", repo, dir}
 	args = append(args, flagArgsFromOptions(o)...)
 
-	cmd := exec.CommandContext(ctx, gitPath, args...)
-
-	if _, err := cmd.Output(); err != nil {
+	if _, err := r.Output(ctx, r.Command("", args...)); err != nil {
+		// Exec has always returned the *exec.ExitError directly, keep doing so
+		var exitErr *runner.ExitError
+		if errors.As(err, &exitErr) {
+			var execErr *exec.ExitError
+			if errors.As(exitErr.Err, &execErr) && execErr.ProcessState != nil {
+				return execErr
+			}
+		}
 		return err
 	}
 
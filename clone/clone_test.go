@@ -2,10 +2,13 @@ package clone
 
 import (
 	"context"
+	"errors"
 	"os/exec"
 	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/mergestat/gitutils/runner"
 )
 
 func TestSingleArgsOK(t *testing.T) {
@@ -90,3 +93,30 @@ func TestErrHandling(t *testing.T) {
 		t.Fatalf("expected Stderr to include: \"%s\", got: %s", contains, got)
 	}
 }
+
+func TestErrHandlingReplay(t *testing.T) {
+	fixtures, dir := t.TempDir(), t.TempDir()
+
+	rec := runner.New(runner.WithExecutor(runner.NewRecorder(fixtures, nil)))
+	if err := Exec(context.Background(), "some-invalid-repo", dir, WithRunner(rec)); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	rep := runner.New(runner.WithExecutor(runner.NewReplayer(fixtures)))
+	err := Exec(context.Background(), "some-invalid-repo", dir, WithRunner(rep))
+
+	var exitErr *runner.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode != 128 {
+		t.Fatalf("expected a *runner.ExitError with the recorded exit code, got: %#v", err)
+	}
+
+	var execErr *exec.ExitError
+	if !errors.As(err, &execErr) {
+		t.Fatalf("expected the error to wrap an *exec.ExitError, got: %v", err)
+	}
+
+	contains := "fatal: repository 'some-invalid-repo' does not exist"
+	if !strings.Contains(err.Error(), contains) || !strings.Contains(string(execErr.Stderr), contains) {
+		t.Fatalf("expected the error and Stderr to include: \"%s\", got: %s", contains, err)
+	}
+}
@@ -0,0 +1,270 @@
+package gitlog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mergestat/gitutils/internal/diffstatus"
+)
+
+// ChangeType is the status of a file changed in a commit, as reported by `git log --name-status`
+// See here: https://git-scm.com/docs/git-log#Documentation/git-log.txt---diff-filterACDMRTUXB82308203
+type ChangeType = diffstatus.ChangeType
+
+const (
+	Added       = diffstatus.Added
+	Copied      = diffstatus.Copied
+	Deleted     = diffstatus.Deleted
+	Modified    = diffstatus.Modified
+	Renamed     = diffstatus.Renamed
+	TypeChanged = diffstatus.TypeChanged
+	Unmerged    = diffstatus.Unmerged
+	Unknown     = diffstatus.Unknown
+)
+
+// recordSeparator is the field that starts every commit in -z mode
+const recordSeparator = "\x1e"
+
+// nulPlaceholders returns the placeholders of each field emitted per commit in -z mode.
+// Each commit starts with a recordSeparator field, and the commit message is always the
+// last field (it's terminated by the NUL git writes after each commit in -z mode).
+func nulPlaceholders(o *execOptions) []string {
+	placeholders := []string{"%x1e", "%H", "%T", "%P", "%aN", "%aE", "%aI", "%cN", "%cE", "%cI"}
+	if o.Signatures {
+		placeholders = append(placeholders, signaturePlaceholders...)
+	}
+	if o.Trailers {
+		placeholders = append(placeholders, trailersPlaceholder)
+	}
+	if o.Refs {
+		placeholders = append(placeholders, "%D")
+	}
+	return append(placeholders, "%B")
+}
+
+// buildNULFormatString constructs the format string used in -z mode, where every field is NUL-separated.
+// Git doesn't allow NUL bytes in any of these fields, so the output can't be confused by their content.
+func buildNULFormatString(o *execOptions) string {
+	return strings.Join(nulPlaceholders(o), "%x00")
+}
+
+// token returns the next NUL-separated token, or false if there are no more
+// (in which case i.readErr is set if reading failed for a reason other than io.EOF)
+func (i *commitIterator) token() (string, bool) {
+	if i.pending != nil {
+		tok := *i.pending
+		i.pending = nil
+		return tok, true
+	}
+
+	tok, err := i.reader.ReadString(0)
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			i.readErr = err
+			return "", false
+		}
+		return tok, tok != "" // the final token may not be NUL-terminated
+	}
+
+	return tok[:len(tok)-1], true
+}
+
+// readNULCommit reads a single commit (and its diff output) from -z output
+func (i *commitIterator) readNULCommit() (*Commit, error) {
+	placeholders := nulPlaceholders(i.options)
+	fields := make(map[string]string, len(placeholders))
+	for n, placeholder := range placeholders {
+		tok, ok := i.token()
+		if !ok {
+			if err := i.readErr; err != nil {
+				_ = i.Close()
+				return nil, err
+			}
+			if err := i.wait(); err != nil {
+				return nil, err
+			}
+			if n == 0 {
+				return nil, nil // no more commits
+			}
+			return nil, fmt.Errorf("unexpected end of git log output: %w", io.ErrUnexpectedEOF)
+		}
+		fields[placeholder] = tok
+	}
+
+	if tok := fields["%x1e"]; tok != recordSeparator {
+		return nil, fmt.Errorf("unexpected token in git log output, expected the start of a commit: %q", tok)
+	}
+
+	commit := &Commit{
+		SHA:       fields["%H"],
+		Tree:      fields["%T"],
+		Parents:   strings.Fields(fields["%P"]),
+		Author:    Event{Name: fields["%aN"], Email: fields["%aE"]},
+		Committer: Event{Name: fields["%cN"], Email: fields["%cE"]},
+		Message:   fields["%B"],
+		Stats:     make([]Stat, 0),
+	}
+
+	var err error
+	if commit.Author.When, err = time.Parse(isoDataFmtStr, fields["%aI"]); err != nil {
+		return nil, err
+	}
+	if commit.Committer.When, err = time.Parse(isoDataFmtStr, fields["%cI"]); err != nil {
+		return nil, err
+	}
+
+	if i.options.Signatures {
+		commit.Signature = signatureFromFields(fields["%G?"], fields["%GS"], fields["%GK"], fields["%GF"], fields["%GT"])
+	}
+
+	if i.options.Trailers {
+		commit.Trailers = parseTrailers(fields[trailersPlaceholder])
+	}
+
+	if i.options.Refs {
+		commit.Refs = parseRefs(fields["%D"])
+	}
+
+	if err := i.readNULDiff(commit); err != nil {
+		return nil, err
+	}
+
+	return commit, nil
+}
+
+// rawEntry is a single entry of `git log --raw` output
+type rawEntry struct {
+	status     ChangeType
+	similarity int
+	oldPath    string
+	path       string
+}
+
+// readNULDiff reads the --raw and --numstat output following a commit header, until the next commit
+func (i *commitIterator) readNULDiff(commit *Commit) error {
+	// raw entries are matched to numstat entries by path, as with -w git leaves files with only
+	// whitespace changes out of --numstat but not out of --raw
+	raw := make(map[string]rawEntry)
+	first := true
+
+	for {
+		tok, ok := i.token()
+		if !ok {
+			return nil // the caller deals with the end of output (and any errors)
+		}
+
+		if first {
+			// git separates the commit header from its diff output with a newline
+			tok = strings.TrimPrefix(tok, "\n")
+			first = false
+		}
+
+		switch {
+		case strings.HasPrefix(tok, ":"):
+			entry, err := i.parseRawEntry(tok)
+			if err != nil {
+				return err
+			}
+			raw[entry.path] = *entry
+		case strings.Contains(tok, "\t"):
+			stat, err := i.parseNumstatEntry(tok)
+			if err != nil {
+				return err
+			}
+			if entry, ok := raw[stat.FilePath]; ok {
+				stat.Status = entry.status
+				stat.Similarity = entry.similarity
+				stat.OldFilePath = entry.oldPath
+			}
+			commit.Stats = append(commit.Stats, *stat)
+		case tok == recordSeparator:
+			// the start of the next commit
+			i.pending = &tok
+			return nil
+		case tok == "" && i.options.patch():
+			// patch output follows an empty token, and runs up to the next commit
+			return i.readPatch(commit)
+		default:
+			return fmt.Errorf("unexpected token in git log diff output: %q", tok)
+		}
+	}
+}
+
+// parseRawEntry parses a --raw -z entry, which looks like ":<old mode> <new mode> <old sha> <new sha> <status>",
+// followed by one path (or two, for copies and renames) in the tokens that follow it.
+func (i *commitIterator) parseRawEntry(tok string) (*rawEntry, error) {
+	s := strings.Split(tok, " ")
+	if len(s) != 5 || len(s[4]) == 0 {
+		return nil, fmt.Errorf("could not parse raw diff entry: %q", tok)
+	}
+
+	entry := &rawEntry{status: ChangeType(s[4][0])}
+	if score := s[4][1:]; score != "" {
+		var err error
+		if entry.similarity, err = strconv.Atoi(score); err != nil {
+			return nil, fmt.Errorf("could not parse raw diff entry: %q: %w", tok, err)
+		}
+	}
+
+	var ok bool
+	if entry.path, ok = i.token(); !ok {
+		return nil, fmt.Errorf("unexpected end of raw diff entry: %w", io.ErrUnexpectedEOF)
+	}
+
+	switch entry.status {
+	case Copied, Renamed:
+		entry.oldPath = entry.path
+		if entry.path, ok = i.token(); !ok {
+			return nil, fmt.Errorf("unexpected end of raw diff entry: %w", io.ErrUnexpectedEOF)
+		}
+	case Added:
+	default:
+		entry.oldPath = entry.path
+	}
+
+	return entry, nil
+}
+
+// parseNumstatEntry parses a --numstat -z entry, which looks like "<additions>\t<deletions>\t<path>",
+// or "<additions>\t<deletions>\t" followed by the old and new paths in the next two tokens for copies and renames.
+func (i *commitIterator) parseNumstatEntry(tok string) (*Stat, error) {
+	s := strings.SplitN(tok, "\t", 3)
+	if len(s) != 3 {
+		return nil, fmt.Errorf("could not parse numstat entry: %q", tok)
+	}
+
+	additions, err := parseNumstatCount(s[0])
+	if err != nil {
+		return nil, err
+	}
+
+	deletions, err := parseNumstatCount(s[1])
+	if err != nil {
+		return nil, err
+	}
+
+	stat := &Stat{FilePath: s[2], Additions: additions, Deletions: deletions}
+	if stat.FilePath == "" {
+		var ok bool
+		if stat.OldFilePath, ok = i.token(); !ok {
+			return nil, fmt.Errorf("unexpected end of numstat entry: %w", io.ErrUnexpectedEOF)
+		}
+		if stat.FilePath, ok = i.token(); !ok {
+			return nil, fmt.Errorf("unexpected end of numstat entry: %w", io.ErrUnexpectedEOF)
+		}
+	}
+
+	return stat, nil
+}
+
+// parseNumstatCount parses the additions or deletions column of numstat output, where "-" (binary files) is -1
+func parseNumstatCount(s string) (int, error) {
+	if s == "-" {
+		return -1, nil
+	}
+	return strconv.Atoi(s)
+}
@@ -7,10 +7,11 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"os/exec"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/mergestat/gitutils/runner"
 )
 
 const isoDataFmtStr = "2006-01-02T15:04:05-07:00"
@@ -27,10 +28,19 @@ const (
 	committerEmailPrefix = "_cE:"
 	committerDatePrefix  = "_cI:"
 	commitBodyPrefix     = "_B:"
+
+	signatureStatusPrefix       = "_G?:"
+	signerPrefix                = "_GS:"
+	signingKeyPrefix            = "_GK:"
+	signingKeyFingerprintPrefix = "_GF:"
+	signatureTrustLevelPrefix   = "_GT:"
+
+	trailersPrefix = "_Tr:"
+	refsPrefix     = "_D:"
 )
 
 // buildFormatString constructs a format string to pass to `git log`
-func buildFormatString() string {
+func buildFormatString(o *execOptions) string {
 	var b strings.Builder
 	b.WriteString(commitHashPrefix + "%H%n")
 	b.WriteString(treeHashPrefix + "%T%n")
@@ -44,6 +54,22 @@ func buildFormatString() string {
 	b.WriteString(committerEmailPrefix + "%cE%n")
 	b.WriteString(committerDatePrefix + "%cI%n")
 
+	if o.Signatures {
+		b.WriteString(signatureStatusPrefix + "%G?%n")
+		b.WriteString(signerPrefix + "%GS%n")
+		b.WriteString(signingKeyPrefix + "%GK%n")
+		b.WriteString(signingKeyFingerprintPrefix + "%GF%n")
+		b.WriteString(signatureTrustLevelPrefix + "%GT%n")
+	}
+
+	if o.Trailers {
+		b.WriteString(trailersPrefix + trailersPlaceholder + "%n")
+	}
+
+	if o.Refs {
+		b.WriteString(refsPrefix + "%D%n")
+	}
+
 	b.WriteString(commitBodyPrefix + "%B%n%x00")
 
 	return b.String()
@@ -58,6 +84,10 @@ type Commit struct {
 	Committer          Event
 	Message            string
 	Stats              []Stat
+	Signature          *Signature // only set when using WithSignatures
+	Trailers           []Trailer  // only set when using WithTrailers
+	Refs               []Ref      // only set when using WithRefs
+	Diffs              []FileDiff // only set when using WithPatch
 	hasTrailingNewline bool
 }
 
@@ -73,6 +103,12 @@ type Stat struct {
 	FilePath  string
 	Additions int
 	Deletions int
+	// Status, OldFilePath and Similarity are only set when using WithNameStatus.
+	// OldFilePath is the path before the change, it differs from FilePath for copies and renames,
+	// and is empty for added files. Similarity is the percentage reported for copies and renames.
+	Status      ChangeType
+	OldFilePath string
+	Similarity  int
 }
 
 type execOptions struct {
@@ -84,6 +120,34 @@ type execOptions struct {
 	M            bool
 	Stats        bool
 	MaxCount     int
+	Revisions    []string
+	Pathspecs    []string
+	Since        string
+	Until        string
+	Author       string
+	Grep         string
+	Signatures   bool
+	Trailers     bool
+	Refs         bool
+	Patch        bool
+	Unified      *int
+	DiffFilter   string
+	WordDiff     bool
+	NameStatus   bool
+	NULDelimited bool
+	FindRenames  bool
+	FindCopies   bool
+	Runner       *runner.Runner
+}
+
+// nulDelimited reports whether git should be run with -z
+func (o *execOptions) nulDelimited() bool {
+	return o.NULDelimited || o.NameStatus || o.patch()
+}
+
+// patch reports whether git should output patches, all of these options imply -p
+func (o *execOptions) patch() bool {
+	return o.Patch || o.WordDiff || o.Unified != nil
 }
 
 type CommitOrder string
@@ -109,6 +173,9 @@ func WithIncludeStats(includeStats bool) Option {
 	}
 }
 
+// WithFileFilter limits the log to commits touching the given path.
+//
+// Deprecated: use WithPathspecs, which supports more than one path.
 func WithFileFilter(fileFilter string) Option {
 	return func(o *execOptions) {
 		o.FileFilter = fileFilter
@@ -145,11 +212,189 @@ func WithMaxCount(n int) Option {
 	}
 }
 
+// WithRevisions sets the revisions to walk, instead of the default of HEAD.
+// Entries can be anything `git log` accepts as a revision or range, such as "main", "v1.0..v2.0",
+// "main...feature" or "^origin/main", as well as the --all, --branches[=<pattern>], --tags[=<pattern>],
+// --remotes[=<pattern>], --glob=<pattern>, --exclude=<pattern> and --not pseudo-revisions.
+// Order matters, for instance --not applies to the revisions that follow it.
+// See here: https://git-scm.com/docs/git-log#_description
+func WithRevisions(revisions []string) Option {
+	return func(o *execOptions) {
+		o.Revisions = revisions
+	}
+}
+
+// WithPathspecs limits the log to commits touching the given paths.
+// They're passed after a `--` separator, so they're never mistaken for revisions.
+// See here: https://git-scm.com/docs/git-log#Documentation/git-log.txt---ltpathgt82308203
+func WithPathspecs(pathspecs []string) Option {
+	return func(o *execOptions) {
+		o.Pathspecs = pathspecs
+	}
+}
+
+// WithSince sets the --since <date> flag
+func WithSince(since string) Option {
+	return func(o *execOptions) {
+		o.Since = since
+	}
+}
+
+// WithUntil sets the --until <date> flag
+func WithUntil(until string) Option {
+	return func(o *execOptions) {
+		o.Until = until
+	}
+}
+
+// WithAuthor sets the --author <pattern> flag
+func WithAuthor(author string) Option {
+	return func(o *execOptions) {
+		o.Author = author
+	}
+}
+
+// WithGrep sets the --grep <pattern> flag
+func WithGrep(grep string) Option {
+	return func(o *execOptions) {
+		o.Grep = grep
+	}
+}
+
+// WithNULDelimited runs git with -z and a format that separates every field with a NUL byte,
+// instead of the default line-based format. Git doesn't allow NUL bytes in commit messages, names or
+// paths, so parsing can't be thrown off by their content (a message line starting with "_H:", a path
+// with a tab or a newline...). In this mode, FilePath is always the new path of a renamed file
+// (rather than git's "old => new" notation) and paths are never quoted.
+func WithNULDelimited(nulDelimited bool) Option {
+	return func(o *execOptions) {
+		o.NULDelimited = nulDelimited
+	}
+}
+
+// WithNameStatus adds the change status (added, modified, renamed...) of each file to Stats,
+// along with the old path and similarity of copies and renames. It implies WithStats(true)
+// and WithNULDelimited(true).
+func WithNameStatus(nameStatus bool) Option {
+	return func(o *execOptions) {
+		o.NameStatus = nameStatus
+	}
+}
+
+// WithFindRenames sets the -M (--find-renames) flag
+func WithFindRenames(findRenames bool) Option {
+	return func(o *execOptions) {
+		o.FindRenames = findRenames
+	}
+}
+
+// WithFindCopies sets the -C (--find-copies) flag
+func WithFindCopies(findCopies bool) Option {
+	return func(o *execOptions) {
+		o.FindCopies = findCopies
+	}
+}
+
+// WithSignatures verifies the GPG (or SSH) signature of each commit, and sets Commit.Signature with the result.
+// Verification uses the same configuration as `git log --show-signature`, such as gpg.ssh.allowedSignersFile.
+func WithSignatures(signatures bool) Option {
+	return func(o *execOptions) {
+		o.Signatures = signatures
+	}
+}
+
+// WithTrailers parses the trailers of each commit message (Signed-off-by, Co-authored-by...) into Commit.Trailers.
+// Git does the parsing, so the result matches `git interpret-trailers --parse`, including any trailer.separators config.
+func WithTrailers(trailers bool) Option {
+	return func(o *execOptions) {
+		o.Trailers = trailers
+	}
+}
+
+// WithRefs decorates each commit with the refs that point at it (branches, remote branches, tags and HEAD) in Commit.Refs
+func WithRefs(refs bool) Option {
+	return func(o *execOptions) {
+		o.Refs = refs
+	}
+}
+
+// WithPatch adds the patch of each changed file to Commit.Diffs, parsed into hunks.
+// It implies WithNameStatus(true), so Stats and Diffs line up one-to-one.
+// Patches are read as the output is streamed, only the current commit is held in memory.
+func WithPatch(patch bool) Option {
+	return func(o *execOptions) {
+		o.Patch = patch
+	}
+}
+
+// WithUnified sets the --unified=<n> flag, the number of context lines in each hunk. It implies WithPatch(true).
+func WithUnified(n int) Option {
+	return func(o *execOptions) {
+		o.Unified = &n
+	}
+}
+
+// WithDiffFilter sets the --diff-filter=<filter> flag, for instance "AM" to only include added and modified files
+// See here: https://git-scm.com/docs/git-log#Documentation/git-log.txt---diff-filterACDMRTUXB82308203
+func WithDiffFilter(filter string) Option {
+	return func(o *execOptions) {
+		o.DiffFilter = filter
+	}
+}
+
+// WithWordDiff sets the --word-diff=porcelain flag. It implies WithPatch(true).
+// Hunk lines are then words or runs of words, and lines of type WordDiffNewline mark the end of a line.
+func WithWordDiff(wordDiff bool) Option {
+	return func(o *execOptions) {
+		o.WordDiff = wordDiff
+	}
+}
+
+// WithRunner sets the *runner.Runner used to invoke git
+func WithRunner(r *runner.Runner) Option {
+	return func(o *execOptions) {
+		o.Runner = r
+	}
+}
+
+// ExitError is returned when the git process exits with a non-zero status
+type ExitError = runner.ExitError
+
 type commitIterator struct {
 	// scanner is a Scanner produced from the Stdout of the `git log ...` command
 	scanner       *bufio.Scanner
-	stderr        io.ReadCloser
+	proc          runner.Process
 	currentCommit *Commit
+	done          bool
+	err           error
+	options       *execOptions
+	// nul is set when git is run with -z, in which case output is read from reader rather than scanner.
+	// pending holds a token read ahead by the -z parser, and readErr any error reading from reader.
+	nul     bool
+	reader  *bufio.Reader
+	pending *string
+	readErr error
+}
+
+// wait reaps the git process once its output is exhausted
+func (i *commitIterator) wait() error {
+	if !i.done {
+		i.done = true
+		i.err = i.proc.Wait()
+	}
+	return i.err
+}
+
+// Close stops the underlying git process if it's still running and releases its resources.
+// It's safe to call Close more than once, and after iteration has completed.
+func (i *commitIterator) Close() error {
+	if i.done {
+		return nil
+	}
+	i.done = true
+	i.currentCommit = nil
+	i.pending = nil
+	return i.proc.Close()
 }
 
 // readUntilCompleteCommit reads from the scanner until it has a complete commit
@@ -158,6 +403,16 @@ func (i *commitIterator) readUntilCompleteCommit() (*Commit, error) {
 	for i.scanner.Scan() {
 		line := i.scanner.Text()
 
+		// the message can contain anything, including lines that look like the other fields
+		if inCommitBody {
+			if strings.HasPrefix(line, string([]byte{0})) {
+				inCommitBody = false
+			} else {
+				i.currentCommit.Message += line + "\n"
+			}
+			continue
+		}
+
 		switch {
 		case strings.HasPrefix(line, commitHashPrefix):
 			commitToReturn := i.currentCommit
@@ -175,7 +430,7 @@ func (i *commitIterator) readUntilCompleteCommit() (*Commit, error) {
 		case strings.HasPrefix(line, treeHashPrefix):
 			i.currentCommit.Tree = strings.TrimPrefix(line, treeHashPrefix)
 		case strings.HasPrefix(line, parentHashesPrefix):
-			i.currentCommit.Parents = append(i.currentCommit.Parents, strings.TrimPrefix(line, parentHashesPrefix))
+			i.currentCommit.Parents = append(i.currentCommit.Parents, strings.Fields(strings.TrimPrefix(line, parentHashesPrefix))...)
 		case strings.HasPrefix(line, authorNamePrefix):
 			i.currentCommit.Author.Name = strings.TrimPrefix(line, authorNamePrefix)
 		case strings.HasPrefix(line, authorEmailPrefix):
@@ -198,19 +453,27 @@ func (i *commitIterator) readUntilCompleteCommit() (*Commit, error) {
 			} else {
 				i.currentCommit.Committer.When = t
 			}
+		case i.options.Signatures && strings.HasPrefix(line, signatureStatusPrefix):
+			i.currentCommit.Signature = &Signature{Status: SignatureStatus(strings.TrimPrefix(line, signatureStatusPrefix))}
+		case i.options.Signatures && i.currentCommit.Signature != nil && strings.HasPrefix(line, signerPrefix):
+			i.currentCommit.Signature.Signer = strings.TrimPrefix(line, signerPrefix)
+		case i.options.Signatures && i.currentCommit.Signature != nil && strings.HasPrefix(line, signingKeyPrefix):
+			i.currentCommit.Signature.Key = strings.TrimPrefix(line, signingKeyPrefix)
+		case i.options.Signatures && i.currentCommit.Signature != nil && strings.HasPrefix(line, signingKeyFingerprintPrefix):
+			i.currentCommit.Signature.Fingerprint = strings.TrimPrefix(line, signingKeyFingerprintPrefix)
+		case i.options.Signatures && i.currentCommit.Signature != nil && strings.HasPrefix(line, signatureTrustLevelPrefix):
+			i.currentCommit.Signature.TrustLevel = strings.TrimPrefix(line, signatureTrustLevelPrefix)
+		case i.options.Trailers && strings.HasPrefix(line, trailersPrefix):
+			i.currentCommit.Trailers = parseTrailers(strings.TrimPrefix(line, trailersPrefix))
+		case i.options.Refs && strings.HasPrefix(line, refsPrefix):
+			i.currentCommit.Refs = parseRefs(strings.TrimPrefix(line, refsPrefix))
 		case strings.HasPrefix(line, commitBodyPrefix):
 			inCommitBody = true
 			s := strings.TrimPrefix(line, commitBodyPrefix)
 			i.currentCommit.Message = s + "\n"
 		case strings.HasPrefix(line, string([]byte{0})):
-			inCommitBody = false
 		default:
-			if inCommitBody {
-				i.currentCommit.Message += line + "\n"
-				continue
-			} else {
-				i.currentCommit.hasTrailingNewline = true
-			}
+			i.currentCommit.hasTrailingNewline = true
 			s := strings.Split(line, "\t")
 			if len(s) != 3 {
 				continue
@@ -243,6 +506,12 @@ func (i *commitIterator) readUntilCompleteCommit() (*Commit, error) {
 	}
 
 	if err := i.scanner.Err(); err != nil {
+		_ = i.Close()
+		return nil, err
+	}
+
+	// the output is exhausted, reap the process and report a failed exit
+	if err := i.wait(); err != nil {
 		return nil, err
 	}
 
@@ -253,9 +522,22 @@ func (i *commitIterator) readUntilCompleteCommit() (*Commit, error) {
 }
 
 // Next moves the iterator and returns the next *Commit (or error)
-// If the returned err is io.EOF then there are no more commits to iterate over
+// If the returned err is io.EOF then there are no more commits to iterate over.
+// If git exits with a non-zero status, the returned error is an *ExitError.
 func (i *commitIterator) Next() (*Commit, error) {
-	if commit, err := i.readUntilCompleteCommit(); err != nil {
+	if i.done && i.currentCommit == nil && i.pending == nil {
+		if i.err != nil {
+			return nil, i.err
+		}
+		return nil, io.EOF
+	}
+
+	read := i.readUntilCompleteCommit
+	if i.nul {
+		read = i.readNULCommit
+	}
+
+	if commit, err := read(); err != nil {
 		return nil, err // there is an error reading the next commit
 	} else if commit != nil {
 		return commit, nil // there is a commit to return, with no error
@@ -264,21 +546,40 @@ func (i *commitIterator) Next() (*Commit, error) {
 	}
 }
 
-// Exec runs the git log command against a repository on disk and returns an iterator
-// for walking over all the commits returned.
-func Exec(ctx context.Context, repoPath string, options ...Option) (*commitIterator, error) {
-	o := &execOptions{}
-	for _, option := range options {
-		option(o)
+// validateRevision returns an error if rev looks like a flag, but isn't one of the
+// pseudo-revisions we allow in the list of revisions
+func validateRevision(rev string) error {
+	if !strings.HasPrefix(rev, "-") {
+		return nil
 	}
 
-	gitPath, err := exec.LookPath("git")
-	if err != nil {
-		return nil, fmt.Errorf("could not find git: %w", err)
+	switch rev {
+	case "--all", "--not", "--branches", "--tags", "--remotes":
+		return nil
+	}
+
+	for _, prefix := range []string{"--branches=", "--tags=", "--remotes=", "--glob=", "--exclude="} {
+		if strings.HasPrefix(rev, prefix) {
+			return nil
+		}
 	}
 
+	return fmt.Errorf("invalid revision %q: only the --all, --not, --branches, --tags, --remotes, --glob and --exclude flags are allowed", rev)
+}
+
+// argsFromOptions returns the arguments to `git log` for the given options
+func argsFromOptions(o *execOptions) ([]string, error) {
 	args := []string{"log"}
-	args = append(args, fmt.Sprintf("--format=%s", buildFormatString()), "--no-decorate", "-w")
+	if o.nulDelimited() {
+		args = append(args, fmt.Sprintf("--format=%s", buildNULFormatString(o)), "-z")
+	} else {
+		args = append(args, fmt.Sprintf("--format=%s", buildFormatString(o)))
+	}
+	if o.Refs {
+		args = append(args, "--decorate=full", "-w")
+	} else {
+		args = append(args, "--no-decorate", "-w")
+	}
 	if o.NoMerges {
 		args = append(args, "--no-merges")
 	}
@@ -299,32 +600,104 @@ func Exec(ctx context.Context, repoPath string, options ...Option) (*commitItera
 		args = append(args, fmt.Sprintf("--max-count=%d", o.MaxCount))
 	}
 
-	if o.FileFilter != "" {
-		args = append(args, o.FileFilter)
+	if o.Since != "" {
+		args = append(args, fmt.Sprintf("--since=%s", o.Since))
 	}
 
-	if o.Stats {
+	if o.Until != "" {
+		args = append(args, fmt.Sprintf("--until=%s", o.Until))
+	}
+
+	if o.Author != "" {
+		args = append(args, fmt.Sprintf("--author=%s", o.Author))
+	}
+
+	if o.Grep != "" {
+		args = append(args, fmt.Sprintf("--grep=%s", o.Grep))
+	}
+
+	if o.NameStatus || o.patch() {
+		args = append(args, "--raw")
+	}
+
+	if o.Stats || o.NameStatus || o.patch() {
 		args = append(args, "--numstat")
 	}
 
-	cmd := exec.CommandContext(ctx, gitPath, args...)
-	cmd.Dir = repoPath
+	if o.patch() {
+		args = append(args, "--patch")
+	}
+
+	if o.Unified != nil {
+		args = append(args, fmt.Sprintf("--unified=%d", *o.Unified))
+	}
+
+	if o.WordDiff {
+		args = append(args, "--word-diff=porcelain")
+	}
+
+	if o.DiffFilter != "" {
+		args = append(args, fmt.Sprintf("--diff-filter=%s", o.DiffFilter))
+	}
+
+	if o.FindRenames {
+		args = append(args, "-M")
+	}
+
+	if o.FindCopies {
+		args = append(args, "-C")
+	}
+
+	for _, rev := range o.Revisions {
+		if err := validateRevision(rev); err != nil {
+			return nil, err
+		}
+		args = append(args, rev)
+	}
+
+	pathspecs := o.Pathspecs
+	if o.FileFilter != "" {
+		pathspecs = append([]string{o.FileFilter}, pathspecs...)
+	}
+
+	// NOTE: pathspecs must come last, after the separator
+	if len(pathspecs) > 0 {
+		args = append(args, "--")
+		args = append(args, pathspecs...)
+	}
+
+	return args, nil
+}
 
-	stdout, err := cmd.StdoutPipe()
+// Exec runs the git log command against a repository on disk and returns an iterator
+// for walking over all the commits returned. Callers that stop iterating before
+// io.EOF should call Close() on the iterator to stop and reap the git process.
+func Exec(ctx context.Context, repoPath string, options ...Option) (*commitIterator, error) {
+	o := &execOptions{}
+	for _, option := range options {
+		option(o)
+	}
+
+	r := o.Runner
+	if r == nil {
+		r = runner.New()
+	}
+
+	args, err := argsFromOptions(o)
 	if err != nil {
 		return nil, err
 	}
 
-	stderr, err := cmd.StderrPipe()
+	proc, err := r.Start(ctx, r.Command(repoPath, args...))
 	if err != nil {
 		return nil, err
 	}
 
-	if err := cmd.Start(); err != nil {
-		return nil, err
+	if o.nulDelimited() {
+		return &commitIterator{reader: bufio.NewReader(proc.Stdout()), proc: proc, options: o, nul: true}, nil
 	}
 
-	scanner := bufio.NewScanner(stdout)
+	scanner := bufio.NewScanner(proc.Stdout())
 
 	// this is a custom split function based off the default bufio.ScanLines one
 	// https://cs.opensource.google/go/go/+/refs/tags/go1.19.1:src/bufio/scan.go;l=350;drc=18888751828c329ddf5efdd7ec1b39adf0b6ea00
@@ -347,7 +720,8 @@ func Exec(ctx context.Context, repoPath string, options ...Option) (*commitItera
 
 	iter := &commitIterator{
 		scanner: scanner,
-		stderr:  stderr,
+		proc:    proc,
+		options: o,
 	}
 
 	return iter, nil
@@ -370,6 +744,22 @@ func (c *Commit) String() string {
 	s.WriteString(fmt.Sprintf("%s%s\n", committerEmailPrefix, c.Committer.Email))
 	s.WriteString(fmt.Sprintf("%s%s\n", committerDatePrefix, c.Committer.When.Format(isoDataFmtStr)))
 
+	if sig := c.Signature; sig != nil {
+		s.WriteString(fmt.Sprintf("%s%s\n", signatureStatusPrefix, sig.Status))
+		s.WriteString(fmt.Sprintf("%s%s\n", signerPrefix, sig.Signer))
+		s.WriteString(fmt.Sprintf("%s%s\n", signingKeyPrefix, sig.Key))
+		s.WriteString(fmt.Sprintf("%s%s\n", signingKeyFingerprintPrefix, sig.Fingerprint))
+		s.WriteString(fmt.Sprintf("%s%s\n", signatureTrustLevelPrefix, sig.TrustLevel))
+	}
+
+	if c.Trailers != nil {
+		s.WriteString(fmt.Sprintf("%s%s\n", trailersPrefix, formatTrailers(c.Trailers)))
+	}
+
+	if c.Refs != nil {
+		s.WriteString(fmt.Sprintf("%s%s\n", refsPrefix, formatRefs(c.Refs)))
+	}
+
 	message := c.Message
 	// message = strings.TrimSuffix(message, "\n")
 
@@ -12,6 +12,8 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+
+	"github.com/mergestat/gitutils/internal/testrepo"
 )
 
 var (
@@ -135,7 +137,7 @@ func TestLogOutputWithStats(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	cmd := exec.CommandContext(context.Background(), gitPath, "log", "--numstat", "--no-decorate", "-w", fmt.Sprintf("--format=%s", buildFormatString()))
+	cmd := exec.CommandContext(context.Background(), gitPath, "log", "--numstat", "--no-decorate", "-w", fmt.Sprintf("--format=%s", buildFormatString(&execOptions{})))
 	cmd.Dir = repoPath
 
 	want, err := cmd.Output()
@@ -175,3 +177,830 @@ func TestLogOutputWithMaxCount(t *testing.T) {
 		t.Fatalf("mismatch in commit counts, got: %d want: %d", count, wantCount)
 	}
 }
+
+func TestNotARepository(t *testing.T) {
+	iter, err := Exec(context.Background(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = iter.Next()
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("expected an *ExitError, got: %v", err)
+	}
+
+	if exitErr.ExitCode == 0 {
+		t.Fatalf("expected a non-zero exit code")
+	}
+
+	if exitErr.Args[0] != "log" {
+		t.Fatalf("expected args to start with log, got: %v", exitErr.Args)
+	}
+
+	contains := "not a git repository"
+	if !strings.Contains(exitErr.Stderr, contains) {
+		t.Fatalf("expected Stderr to include: \"%s\", got: %s", contains, exitErr.Stderr)
+	}
+
+	// subsequent calls should keep returning the same error
+	if _, err := iter.Next(); !errors.Is(err, exitErr) {
+		t.Fatalf("expected the same error on subsequent calls, got: %v", err)
+	}
+}
+
+func TestCloseEarly(t *testing.T) {
+	iter, err := Exec(context.Background(), repoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := iter.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := iter.Next(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF after Close(), got: %v", err)
+	}
+
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var fixtureCommits = []testrepo.Commit{
+	{Files: map[string]string{"README.md": "# fixture\n", "main.go": "package main\n"}, Message: "initial commit"},
+	{Files: map[string]string{"main.go": "package main\n\nfunc main() {}\n"}, Message: "add main\n\nwith a body that spans\nmultiple lines"},
+	{Files: map[string]string{"docs/guide.md": "guide\n", "README.md": "# fixture\n\nsee docs/\n"}, Message: "add docs"},
+	{Files: map[string]string{"main": "a file named like the branch\n"}, Message: "add a file called main"},
+}
+
+func newFixtureRepo(t testing.TB) string {
+	return testrepo.New(t, fixtureCommits)
+}
+
+func TestFixtureLog(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newFixtureRepo)
+
+	iter, err := Exec(context.Background(), path, WithRunner(r), WithStats(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var commits []*Commit
+	for {
+		commit, err := iter.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatal(err)
+		}
+		commits = append(commits, commit)
+	}
+
+	if len(commits) != len(fixtureCommits) {
+		t.Fatalf("mismatch in commit counts, got: %d want: %d", len(commits), len(fixtureCommits))
+	}
+
+	// commits are returned newest first
+	for n, commit := range commits {
+		want := fixtureCommits[len(fixtureCommits)-1-n]
+
+		if got := strings.TrimRight(commit.Message, "\n"); got != want.Message {
+			t.Errorf("commit %d: got message %q, want %q", n, got, want.Message)
+		}
+
+		if commit.Author.Name != "Fixture Author" || commit.Committer.Email != "committer@example.com" {
+			t.Errorf("commit %d: unexpected author/committer: %v %v", n, commit.Author, commit.Committer)
+		}
+
+		if len(commit.Stats) != len(want.Files) {
+			t.Errorf("commit %d: got %d stats, want %d", n, len(commit.Stats), len(want.Files))
+		}
+
+		for _, stat := range commit.Stats {
+			if _, ok := want.Files[stat.FilePath]; !ok {
+				t.Errorf("commit %d: unexpected stat for %s", n, stat.FilePath)
+			}
+		}
+	}
+
+	if len(commits[0].Parents) != 1 || commits[0].Parents[0] != commits[1].SHA {
+		t.Errorf("unexpected parents: %v", commits[0].Parents)
+	}
+
+	if want := "2022-01-04T12:00:00+01:00"; commits[0].Author.When.Format(isoDataFmtStr) != want {
+		t.Errorf("got author date %s, want %s", commits[0].Author.When.Format(isoDataFmtStr), want)
+	}
+}
+
+func TestArgsFromOptions(t *testing.T) {
+	type test struct {
+		options []Option
+		args    []string
+	}
+
+	tests := []test{
+		{options: []Option{WithSince("2 weeks ago")}, args: []string{"--since=2 weeks ago"}},
+		{options: []Option{WithUntil("2022-01-01")}, args: []string{"--until=2022-01-01"}},
+		{options: []Option{WithAuthor("someone")}, args: []string{"--author=someone"}},
+		{options: []Option{WithGrep("fix")}, args: []string{"--grep=fix"}},
+		{options: []Option{WithRevisions([]string{"main..feature"})}, args: []string{"main..feature"}},
+		{options: []Option{WithRevisions([]string{"--branches=release/*", "--not", "main"})}, args: []string{"--branches=release/*", "--not", "main"}},
+		{options: []Option{WithPathspecs([]string{"a", "b/"})}, args: []string{"--", "a", "b/"}},
+		{options: []Option{WithFileFilter("a"), WithPathspecs([]string{"b"})}, args: []string{"--", "a", "b"}},
+		{options: []Option{WithRevisions([]string{"--all"}), WithPathspecs([]string{"main"})}, args: []string{"--all", "--", "main"}},
+	}
+
+	base, err := argsFromOptions(&execOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args, ","), func(t *testing.T) {
+			o := &execOptions{}
+			for _, opt := range tc.options {
+				opt(o)
+			}
+
+			got, err := argsFromOptions(o)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := append(append([]string{}, base...), tc.args...)
+			if strings.Join(got, "\x00") != strings.Join(want, "\x00") {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+
+	for _, rev := range []string{"--output=/tmp/x", "-p", "--format=%H"} {
+		if _, err := argsFromOptions(&execOptions{Revisions: []string{rev}}); err == nil {
+			t.Errorf("expected an error for revision %q", rev)
+		}
+	}
+}
+
+func TestFixtureFilters(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newFixtureRepo)
+
+	type test struct {
+		name     string
+		options  []Option
+		messages []string
+	}
+
+	tests := []test{
+		{name: "revision", options: []Option{WithRevisions([]string{"HEAD~2"})}, messages: []string{"add main", "initial commit"}},
+		{name: "range", options: []Option{WithRevisions([]string{"HEAD~3..HEAD~1"})}, messages: []string{"add docs", "add main"}},
+		{name: "not", options: []Option{WithRevisions([]string{"--all", "--not", "HEAD~1"})}, messages: []string{"add a file called main"}},
+		{name: "pathspecs", options: []Option{WithPathspecs([]string{"docs", "main.go"})}, messages: []string{"add docs", "add main", "initial commit"}},
+		{name: "pathspec named like a branch", options: []Option{WithPathspecs([]string{"main"})}, messages: []string{"add a file called main"}},
+		{name: "since", options: []Option{WithSince("2022-01-02T13:00:00+01:00")}, messages: []string{"add a file called main", "add docs"}},
+		{name: "until", options: []Option{WithUntil("2022-01-01T13:00:00+01:00")}, messages: []string{"initial commit"}},
+		{name: "author", options: []Option{WithAuthor("nobody")}, messages: nil},
+		{name: "grep", options: []Option{WithGrep("^add")}, messages: []string{"add a file called main", "add docs", "add main"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			iter, err := Exec(context.Background(), path, append(tc.options, WithRunner(r))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for {
+				commit, err := iter.Next()
+				if err != nil {
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					t.Fatal(err)
+				}
+				got = append(got, strings.SplitN(commit.Message, "\n", 2)[0])
+			}
+
+			if strings.Join(got, ",") != strings.Join(tc.messages, ",") {
+				t.Errorf("got %v, want %v", got, tc.messages)
+			}
+		})
+	}
+}
+
+func newRenameRepo(t testing.TB) string {
+	lines := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\n"
+	return testrepo.New(t, []testrepo.Commit{
+		{Files: map[string]string{
+			"a.txt":          lines,
+			"b.txt":          "bee\n",
+			"c.txt":          lines + "nine\n",
+			"bin.dat":        "\x00\x01\x02",
+			"tab\tname.txt":  "tab\n",
+			"café/nl\nx.txt": "newline\n",
+		}, Message: "initial commit"},
+		{Files: map[string]string{
+			"a.txt":          "",
+			"dir/a2.txt":     lines + "nine\nten\n",
+			"b.txt":          "",
+			"c.txt":          lines + "nine\nten\n",
+			"c-copy.txt":     lines + "nine\n",
+			"bin.dat":        "\x00\x01\x03",
+			"tab\tname.txt":  "tab\ntab\n",
+			"café/nl\nx.txt": "",
+		}, Message: "rename, copy, delete and modify"},
+	})
+}
+
+func newWhitespaceRepo(t testing.TB) string {
+	lines := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\n"
+	return testrepo.New(t, []testrepo.Commit{
+		{Files: map[string]string{"1.txt": "one\n", "2.txt": "two\n", "3.txt": "three\n", "4.txt": lines}, Message: "initial commit"},
+		{Files: map[string]string{
+			"1.txt":     "one\nmore\n",
+			"2.txt":     "two \n",
+			"3.txt":     "three\nmore\n",
+			"4.txt":     "",
+			"moved.txt": lines + "nine\n",
+		}, Message: "modify, rename and change whitespace"},
+	})
+}
+
+// with -w, files with only whitespace changes are left out of --numstat but not --raw
+func TestFixtureNameStatusWhitespace(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newWhitespaceRepo)
+
+	iter, err := Exec(context.Background(), path, WithRunner(r), WithNameStatus(true), WithFindRenames(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var commits []*Commit
+	for {
+		commit, err := iter.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatal(err)
+		}
+		commits = append(commits, commit)
+	}
+
+	if len(commits) != 2 {
+		t.Fatalf("mismatch in commit counts, got: %d want: %d", len(commits), 2)
+	}
+
+	var got []string
+	for _, stat := range commits[0].Stats {
+		got = append(got, fmt.Sprintf("%s %s<-%s +%d", stat.Status, stat.FilePath, stat.OldFilePath, stat.Additions))
+	}
+
+	want := []string{"M 1.txt<-1.txt +1", "M 3.txt<-3.txt +1", "R moved.txt<-4.txt +1"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFixtureNameStatus(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newRenameRepo)
+
+	iter, err := Exec(context.Background(), path, WithRunner(r), WithNameStatus(true), WithFindRenames(true), WithFindCopies(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var commits []*Commit
+	for {
+		commit, err := iter.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatal(err)
+		}
+		commits = append(commits, commit)
+	}
+
+	if len(commits) != 2 {
+		t.Fatalf("mismatch in commit counts, got: %d want: %d", len(commits), 2)
+	}
+
+	if len(commits[0].Parents) != 1 || commits[0].Parents[0] != commits[1].SHA || len(commits[1].Parents) != 0 {
+		t.Errorf("unexpected parents: %v %v", commits[0].Parents, commits[1].Parents)
+	}
+
+	if commits[0].Message != "rename, copy, delete and modify\n" {
+		t.Errorf("unexpected message: %q", commits[0].Message)
+	}
+
+	type want struct {
+		status      ChangeType
+		oldFilePath string
+		additions   int
+		deletions   int
+	}
+
+	wants := map[string]want{
+		"dir/a2.txt":     {status: Renamed, oldFilePath: "a.txt", additions: 2},
+		"b.txt":          {status: Deleted, oldFilePath: "b.txt", deletions: 1},
+		"c.txt":          {status: Modified, oldFilePath: "c.txt", additions: 1},
+		"c-copy.txt":     {status: Copied, oldFilePath: "c.txt"},
+		"bin.dat":        {status: Modified, oldFilePath: "bin.dat", additions: -1, deletions: -1},
+		"tab\tname.txt":  {status: Modified, oldFilePath: "tab\tname.txt", additions: 1},
+		"café/nl\nx.txt": {status: Deleted, oldFilePath: "café/nl\nx.txt", deletions: 1},
+	}
+
+	if len(commits[0].Stats) != len(wants) {
+		t.Fatalf("got %d stats, want %d: %+v", len(commits[0].Stats), len(wants), commits[0].Stats)
+	}
+
+	for _, stat := range commits[0].Stats {
+		w, ok := wants[stat.FilePath]
+		if !ok {
+			t.Errorf("unexpected stat for %q", stat.FilePath)
+			continue
+		}
+
+		if stat.Status != w.status || stat.OldFilePath != w.oldFilePath || stat.Additions != w.additions || stat.Deletions != w.deletions {
+			t.Errorf("%q: got %s %q +%d -%d, want %s %q +%d -%d", stat.FilePath,
+				stat.Status, stat.OldFilePath, stat.Additions, stat.Deletions,
+				w.status, w.oldFilePath, w.additions, w.deletions)
+		}
+
+		if (stat.Status == Renamed || stat.Status == Copied) && (stat.Similarity <= 0 || stat.Similarity > 100) {
+			t.Errorf("%q: unexpected similarity %d", stat.FilePath, stat.Similarity)
+		}
+	}
+
+	for _, stat := range commits[1].Stats {
+		if stat.Status != Added || stat.OldFilePath != "" {
+			t.Errorf("%q: got %s %q, want an added file", stat.FilePath, stat.Status, stat.OldFilePath)
+		}
+	}
+}
+
+// adversarialCommits have messages and paths crafted to confuse a line-based parser
+var adversarialCommits = []testrepo.Commit{
+	{Files: map[string]string{"_H:0000000000000000000000000000000000000000": "x\n", "a\tb.txt": "tab\n"}, Message: "_H:0000000000000000000000000000000000000000\n_B:not the body\n\n_T:tree"},
+	{Files: map[string]string{"new\nline.txt": "newline\n", "\x1e": "record separator\n"}, Message: "numstat lookalikes\n\n1\t2\tfake.txt\n:100644 100644 abc def M\nfake.txt\n\x1e"},
+	{Files: map[string]string{"a\tb.txt": "tab\ntab\n"}, Message: "\n\nleading newlines"},
+}
+
+func TestFixtureNULDelimited(t *testing.T) {
+	r, path := testrepo.Fixtures(t, func(t testing.TB) string { return testrepo.New(t, adversarialCommits) })
+
+	iter, err := Exec(context.Background(), path, WithRunner(r), WithNULDelimited(true), WithStats(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var commits []*Commit
+	for {
+		commit, err := iter.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatal(err)
+		}
+		commits = append(commits, commit)
+	}
+
+	if len(commits) != len(adversarialCommits) {
+		t.Fatalf("mismatch in commit counts, got: %d want: %d", len(commits), len(adversarialCommits))
+	}
+
+	for n, commit := range commits {
+		want := adversarialCommits[len(adversarialCommits)-1-n]
+
+		// git strips leading blank lines and adds a trailing newline to messages
+		if got, want := commit.Message, strings.TrimLeft(want.Message, "\n")+"\n"; got != want {
+			t.Errorf("commit %d: got message %q, want %q", n, got, want)
+		}
+
+		if len(commit.SHA) != 40 || len(commit.Tree) != 40 {
+			t.Errorf("commit %d: unexpected hashes %q %q", n, commit.SHA, commit.Tree)
+		}
+
+		if len(commit.Stats) != len(want.Files) {
+			t.Errorf("commit %d: got %d stats, want %d: %+v", n, len(commit.Stats), len(want.Files), commit.Stats)
+		}
+
+		for _, stat := range commit.Stats {
+			if _, ok := want.Files[stat.FilePath]; !ok {
+				t.Errorf("commit %d: unexpected stat for %q", n, stat.FilePath)
+			}
+			if stat.Additions < 1 || stat.Deletions != 0 {
+				t.Errorf("commit %d: %q: unexpected counts +%d -%d", n, stat.FilePath, stat.Additions, stat.Deletions)
+			}
+		}
+	}
+}
+
+// newSignedRepo builds a repo with an unsigned commit, a commit signed by a trusted SSH key
+// and one signed by an SSH key that isn't in the allowed signers file
+func newSignedRepo(t testing.TB) string {
+	dir := testrepo.New(t, nil)
+	keys := t.TempDir()
+
+	for _, name := range []string{"trusted", "unknown"} {
+		cmd := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", name, "-f", filepath.Join(keys, name))
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("could not generate key: %v: %s", err, out)
+		}
+	}
+
+	pub, err := os.ReadFile(filepath.Join(keys, "trusted.pub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allowed := filepath.Join(keys, "allowed_signers")
+	if err := os.WriteFile(allowed, []byte("signer@example.com "+string(pub)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	testrepo.Git(t, dir, 0, "config", "gpg.format", "ssh")
+	testrepo.Git(t, dir, 0, "config", "gpg.ssh.allowedSignersFile", allowed)
+
+	testrepo.Git(t, dir, 0, "commit", "--quiet", "--allow-empty", "--message", "unsigned")
+	testrepo.Git(t, dir, 1, "-c", "user.signingKey="+filepath.Join(keys, "trusted.pub"), "commit", "--quiet", "--allow-empty", "-S", "--message", "trusted")
+	testrepo.Git(t, dir, 2, "-c", "user.signingKey="+filepath.Join(keys, "unknown.pub"), "commit", "--quiet", "--allow-empty", "-S", "--message", "unknown")
+
+	return dir
+}
+
+// prefixMessage has a line starting with each of the prefixes of the line-based output format
+const prefixMessage = "message with prefixes\n\n_H:0123\n_T:0123\n_P:0123\n_aN:Someone\n_aE:someone@example.com\n_aI:2020-01-01T00:00:00Z\n" +
+	"_cN:Someone\n_cE:someone@example.com\n_cI:2020-01-01T00:00:00Z\n_G?:G\n_GS:hello\n_GK:key\n_GF:fingerprint\n_GT:ultimate\n" +
+	"_Tr:Injected: trailer\n_D:HEAD -> injected, tag: injected\n_B:body\n1\t2\tinjected.txt\n\nthe end"
+
+func TestFixturePrefixesInMessage(t *testing.T) {
+	r, path := testrepo.Fixtures(t, func(t testing.TB) string {
+		return testrepo.New(t, []testrepo.Commit{
+			{Files: map[string]string{"a.txt": "a\n"}, Message: "initial commit"},
+			{Files: map[string]string{"b.txt": "b\n"}, Message: prefixMessage},
+		})
+	})
+
+	tests := []struct {
+		name    string
+		options []Option
+	}{
+		{"default", nil},
+		{"all fields", []Option{WithSignatures(true), WithTrailers(true), WithRefs(true)}},
+		{"all fields nul", []Option{WithSignatures(true), WithTrailers(true), WithRefs(true), WithNULDelimited(true)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			iter, err := Exec(context.Background(), path, append([]Option{WithRunner(r), WithStats(true), WithMaxCount(2)}, test.options...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var commits []*Commit
+			for {
+				commit, err := iter.Next()
+				if err != nil {
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					t.Fatal(err)
+				}
+				commits = append(commits, commit)
+			}
+
+			if len(commits) != 2 {
+				t.Fatalf("mismatch in commit counts, got: %d want: %d", len(commits), 2)
+			}
+
+			c := commits[0]
+			if got := strings.TrimRight(c.Message, "\n"); got != prefixMessage {
+				t.Errorf("got message %q, want %q", got, prefixMessage)
+			}
+
+			if c.Author.Name != "Fixture Author" || c.Committer.Email != "committer@example.com" || c.Tree == "0123" || len(c.Parents) != 1 {
+				t.Errorf("unexpected commit header: %+v", c)
+			}
+
+			if len(c.Stats) != 1 || c.Stats[0].FilePath != "b.txt" {
+				t.Errorf("unexpected stats: %+v", c.Stats)
+			}
+
+			if test.options == nil && c.Trailers != nil {
+				t.Errorf("expected no trailers without WithTrailers, got %v", c.Trailers)
+			}
+			if test.options != nil && (c.Trailers == nil || len(c.Trailers) != 0) {
+				t.Errorf("expected an empty list of trailers, got %#v", c.Trailers)
+			}
+
+			if test.options == nil && c.Refs != nil {
+				t.Errorf("expected no refs without WithRefs, got %v", c.Refs)
+			}
+			if want := "[{HEAD HEAD HEAD refs/heads/main} {branch main refs/heads/main }]"; test.options != nil && fmt.Sprint(c.Refs) != want {
+				t.Errorf("got refs %v, want %s", c.Refs, want)
+			}
+
+			if test.options == nil && c.Signature != nil {
+				t.Errorf("expected no signature without WithSignatures, got %+v", c.Signature)
+			}
+			if test.options != nil && (c.Signature == nil || c.Signature.Status != "N" || c.Signature.Signer != "") {
+				t.Errorf("expected an unsigned commit, got %+v", c.Signature)
+			}
+		})
+	}
+}
+
+func TestFixtureSignatures(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newSignedRepo)
+
+	for _, nul := range []bool{false, true} {
+		t.Run(fmt.Sprintf("nul=%v", nul), func(t *testing.T) {
+			iter, err := Exec(context.Background(), path, WithRunner(r), WithSignatures(true), WithNULDelimited(nul))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			signatures := make(map[string]*Signature)
+			for {
+				commit, err := iter.Next()
+				if err != nil {
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					t.Fatal(err)
+				}
+				signatures[strings.TrimSpace(commit.Message)] = commit.Signature
+			}
+
+			if sig := signatures["unsigned"]; sig == nil || sig.Signed() || sig.Status != NoSignature {
+				t.Errorf("unsigned: got %+v", sig)
+			}
+
+			if sig := signatures["trusted"]; sig == nil || !sig.Verified() || sig.Signer != "signer@example.com" || !strings.HasPrefix(sig.Fingerprint, "SHA256:") || sig.TrustLevel != "fully" {
+				t.Errorf("trusted: got %+v", sig)
+			}
+
+			if sig := signatures["unknown"]; sig == nil || !sig.Signed() || sig.Verified() || sig.Status != UnknownValiditySignature || sig.Signer != "" || sig.Key == "" {
+				t.Errorf("unknown: got %+v", sig)
+			}
+		})
+	}
+}
+
+var trailerCommits = []testrepo.Commit{
+	{Message: "no trailers\n\njust a body"},
+	{Message: "with trailers\n\nNot-a-trailer: in the body\n\nSigned-off-by: A <a@example.com>\nCo-authored-by: B\n  <b@example.com>\nco-authored-by: C <c@example.com>\nBug #42\nChange-Id: I123"},
+}
+
+func TestFixtureTrailers(t *testing.T) {
+	r, path := testrepo.Fixtures(t, func(t testing.TB) string {
+		dir := testrepo.New(t, trailerCommits)
+		testrepo.Git(t, dir, 0, "config", "trailer.separators", ":#")
+		return dir
+	})
+
+	for _, nul := range []bool{false, true} {
+		t.Run(fmt.Sprintf("nul=%v", nul), func(t *testing.T) {
+			iter, err := Exec(context.Background(), path, WithRunner(r), WithTrailers(true), WithNULDelimited(nul))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var commits []*Commit
+			for {
+				commit, err := iter.Next()
+				if err != nil {
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					t.Fatal(err)
+				}
+				commits = append(commits, commit)
+			}
+
+			if len(commits) != 2 {
+				t.Fatalf("mismatch in commit counts, got: %d want: %d", len(commits), 2)
+			}
+
+			want := []Trailer{
+				{Key: "Signed-off-by", Value: "A <a@example.com>"},
+				{Key: "Co-authored-by", Value: "B <b@example.com>"},
+				{Key: "co-authored-by", Value: "C <c@example.com>"},
+				{Key: "Bug", Value: "42"},
+				{Key: "Change-Id", Value: "I123"},
+			}
+
+			if fmt.Sprint(commits[0].Trailers) != fmt.Sprint(want) {
+				t.Errorf("got %v, want %v", commits[0].Trailers, want)
+			}
+
+			if got := commits[0].TrailerValues("Co-Authored-By"); len(got) != 2 {
+				t.Errorf("expected 2 co-authors, got %v", got)
+			}
+
+			if commits[1].Trailers == nil || len(commits[1].Trailers) != 0 {
+				t.Errorf("expected an empty list of trailers, got %#v", commits[1].Trailers)
+			}
+		})
+	}
+}
+
+func TestFixtureRefs(t *testing.T) {
+	r, path := testrepo.Fixtures(t, func(t testing.TB) string {
+		dir := testrepo.New(t, fixtureCommits[:3])
+		testrepo.Git(t, dir, 0, "tag", "v1", "HEAD~2")
+		testrepo.Git(t, dir, 0, "tag", "--annotate", "--message", "v2", "v2")
+		testrepo.Git(t, dir, 0, "branch", "feature", "HEAD~1")
+		testrepo.Git(t, dir, 0, "update-ref", "refs/remotes/origin/main", "HEAD~1")
+		return dir
+	})
+
+	for _, nul := range []bool{false, true} {
+		t.Run(fmt.Sprintf("nul=%v", nul), func(t *testing.T) {
+			iter, err := Exec(context.Background(), path, WithRunner(r), WithRefs(true), WithNULDelimited(nul))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for {
+				commit, err := iter.Next()
+				if err != nil {
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					t.Fatal(err)
+				}
+				got = append(got, fmt.Sprint(commit.Refs))
+			}
+
+			want := []string{
+				"[{HEAD HEAD HEAD refs/heads/main} {branch main refs/heads/main } {tag v2 refs/tags/v2 }]",
+				"[{remote origin/main refs/remotes/origin/main } {branch feature refs/heads/feature }]",
+				"[{tag v1 refs/tags/v1 }]",
+			}
+
+			if strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+			}
+		})
+	}
+}
+
+func TestRefsRoundTrip(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"HEAD",
+		"HEAD -> refs/heads/main, tag: refs/tags/v1, refs/remotes/origin/HEAD, refs/remotes/origin/main",
+		"refs/heads/a, refs/stash, tag: refs/tags/v2",
+	} {
+		if got := formatRefs(parseRefs(s)); got != s {
+			t.Errorf("got %q, want %q", got, s)
+		}
+	}
+
+	refs := parseRefs("refs/stash")
+	if len(refs) != 1 || refs[0].Type != OtherRef || refs[0].Name != "stash" {
+		t.Errorf("unexpected refs: %+v", refs)
+	}
+}
+
+func TestFixturePatch(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newRenameRepo)
+
+	commitsWith := func(t *testing.T, options ...Option) []*Commit {
+		iter, err := Exec(context.Background(), path, append(options, WithRunner(r), WithFindRenames(true), WithFindCopies(true))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var commits []*Commit
+		for {
+			commit, err := iter.Next()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				t.Fatal(err)
+			}
+			commits = append(commits, commit)
+		}
+
+		return commits
+	}
+
+	diffFor := func(t *testing.T, commit *Commit, path string) *FileDiff {
+		for n := range commit.Diffs {
+			if commit.Diffs[n].Path == path {
+				return &commit.Diffs[n]
+			}
+		}
+		t.Fatalf("no diff for %q", path)
+		return nil
+	}
+
+	t.Run("patch", func(t *testing.T) {
+		commits := commitsWith(t, WithPatch(true))
+		if len(commits) != 2 {
+			t.Fatalf("mismatch in commit counts, got: %d want: %d", len(commits), 2)
+		}
+
+		for _, commit := range commits {
+			if len(commit.Diffs) != len(commit.Stats) {
+				t.Fatalf("got %d diffs for %d stats", len(commit.Diffs), len(commit.Stats))
+			}
+			for n, diff := range commit.Diffs {
+				if diff.Path != commit.Stats[n].FilePath || diff.Status != commit.Stats[n].Status {
+					t.Errorf("diff %d (%q) doesn't line up with stat %q", n, diff.Path, commit.Stats[n].FilePath)
+				}
+			}
+		}
+
+		c := diffFor(t, commits[0], "c.txt")
+		if len(c.Hunks) != 1 {
+			t.Fatalf("got %d hunks, want 1", len(c.Hunks))
+		}
+		h := c.Hunks[0]
+		if h.OldStart != 7 || h.OldLines != 3 || h.NewStart != 7 || h.NewLines != 4 || h.Section != "six" || len(h.Lines) != 4 {
+			t.Fatalf("unexpected hunk: %+v", h)
+		}
+		if l := h.Lines[3]; l.Type != AddedLine || l.Content != "ten" {
+			t.Errorf("unexpected last line: %+v", l)
+		}
+
+		if a2 := diffFor(t, commits[0], "dir/a2.txt"); a2.OldFilePath != "a.txt" || !strings.HasPrefix(a2.Header[0], "similarity index") {
+			t.Errorf("unexpected rename: %+v", a2)
+		}
+
+		if bin := diffFor(t, commits[0], "bin.dat"); !bin.Binary || len(bin.Hunks) != 0 {
+			t.Errorf("expected a binary diff, got %+v", bin)
+		}
+
+		if deleted := diffFor(t, commits[0], "café/nl\nx.txt"); len(deleted.Hunks) != 1 || deleted.Hunks[0].Lines[0].Type != RemovedLine {
+			t.Errorf("unexpected deletion: %+v", deleted)
+		}
+	})
+
+	t.Run("unified", func(t *testing.T) {
+		commits := commitsWith(t, WithUnified(0))
+		c := diffFor(t, commits[0], "c.txt")
+		if len(c.Hunks) != 1 || len(c.Hunks[0].Lines) != 1 || c.Hunks[0].OldLines != 0 {
+			t.Errorf("expected a single line hunk with no context, got %+v", c.Hunks)
+		}
+	})
+
+	t.Run("diff filter", func(t *testing.T) {
+		commits := commitsWith(t, WithPatch(true), WithDiffFilter("D"))
+		if len(commits) != 1 || len(commits[0].Diffs) != 2 {
+			t.Fatalf("expected only the two deletions, got %+v", commits)
+		}
+		for _, diff := range commits[0].Diffs {
+			if diff.Status != Deleted {
+				t.Errorf("unexpected %s diff for %q", diff.Status, diff.Path)
+			}
+		}
+	})
+
+	t.Run("word diff", func(t *testing.T) {
+		commits := commitsWith(t, WithWordDiff(true))
+		tab := diffFor(t, commits[0], "tab\tname.txt")
+		var types []string
+		for _, l := range tab.Hunks[0].Lines {
+			types = append(types, l.Type.String())
+		}
+		if got := strings.Join(types, ""); got != " ~+~" {
+			t.Errorf("got line types %q, want %q", got, " ~+~")
+		}
+	})
+}
+
+func TestParseHunkHeader(t *testing.T) {
+	h, err := parseHunkHeader("@@ -1 +1,0 @@ func main() {")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.OldStart != 1 || h.OldLines != 1 || h.NewStart != 1 || h.NewLines != 0 || h.Section != "func main() {" {
+		t.Errorf("unexpected hunk: %+v", h)
+	}
+
+	for _, line := range []string{"@@ -1 @@", "@@ 1 +1 @@", "@@ -a +1 @@", "@@@ -1 -1 +1 @@@"} {
+		if _, err := parseHunkHeader(line); err == nil {
+			t.Errorf("expected an error parsing %q", line)
+		}
+	}
+}
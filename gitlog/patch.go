@@ -0,0 +1,169 @@
+package gitlog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// LineType is the kind of a line in a hunk, it's the character the line starts with in the patch
+type LineType byte
+
+const (
+	ContextLine LineType = ' '
+	AddedLine   LineType = '+'
+	RemovedLine LineType = '-'
+	// NoNewlineLine is the "\ No newline at end of file" marker that follows the last line of a file
+	NoNewlineLine LineType = '\\'
+	// WordDiffNewline marks the end of a line in --word-diff=porcelain output
+	WordDiffNewline LineType = '~'
+)
+
+func (t LineType) String() string {
+	return string(rune(t))
+}
+
+// Line is a single line of a hunk, without its leading LineType character (and trailing newline)
+type Line struct {
+	Type    LineType
+	Content string
+}
+
+// Hunk is a single hunk of a patch, starting with a "@@ -<old start>,<old lines> +<new start>,<new lines> @@" header
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	// Section is the (optional) text following the hunk header, usually the enclosing function
+	Section string
+	Lines   []Line
+}
+
+// FileDiff is the patch of a single file changed in a commit
+type FileDiff struct {
+	// Path, OldFilePath and Status are the same as the Stat of the file at the same index in Commit.Stats
+	Path        string
+	OldFilePath string
+	Status      ChangeType
+	// Binary is set for binary files, which have no hunks
+	Binary bool
+	// Header holds the extended header lines that follow "diff --git ...", such as "new file mode 100644",
+	// "similarity index 90%", "index <old>..<new>", "--- a/file" and "+++ b/file"
+	Header []string
+	Hunks  []Hunk
+}
+
+const (
+	diffHeaderPrefix = "diff --git "
+	hunkHeaderPrefix = "@@ "
+)
+
+// readPatch reads the patch output of a commit (from -p), up to the start of the next commit.
+// Git writes the patch of each file in the same order as the --raw and --numstat entries,
+// so the n-th FileDiff describes the same file as the n-th Stat.
+func (i *commitIterator) readPatch(commit *Commit) error {
+	commit.Diffs = make([]FileDiff, 0, len(commit.Stats))
+
+	var diff *FileDiff
+	var hunk *Hunk
+	for {
+		// patch lines never start with the record separator, so it marks the start of the next commit
+		if b, err := i.reader.Peek(1); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		} else if b[0] == recordSeparator[0] {
+			return nil
+		}
+
+		line, err := i.reader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case strings.HasPrefix(line, diffHeaderPrefix):
+			n := len(commit.Diffs)
+			if n >= len(commit.Stats) {
+				return fmt.Errorf("patch for more files than the numstat output of commit %s", commit.SHA)
+			}
+			stat := commit.Stats[n]
+			commit.Diffs = append(commit.Diffs, FileDiff{
+				Path:        stat.FilePath,
+				OldFilePath: stat.OldFilePath,
+				Status:      stat.Status,
+				Header:      make([]string, 0),
+				Hunks:       make([]Hunk, 0),
+			})
+			diff, hunk = &commit.Diffs[n], nil
+		case diff == nil:
+			return fmt.Errorf("unexpected line in patch output: %q", line)
+		case strings.HasPrefix(line, hunkHeaderPrefix):
+			h, err := parseHunkHeader(line)
+			if err != nil {
+				return err
+			}
+			diff.Hunks = append(diff.Hunks, *h)
+			hunk = &diff.Hunks[len(diff.Hunks)-1]
+		case hunk != nil:
+			if line == "" {
+				// some diff settings write empty context lines without the leading space
+				hunk.Lines = append(hunk.Lines, Line{Type: ContextLine})
+				continue
+			}
+			switch t := LineType(line[0]); t {
+			case ContextLine, AddedLine, RemovedLine, NoNewlineLine, WordDiffNewline:
+				hunk.Lines = append(hunk.Lines, Line{Type: t, Content: line[1:]})
+			default:
+				return fmt.Errorf("unexpected line in hunk: %q", line)
+			}
+		default:
+			if strings.HasPrefix(line, "Binary files ") {
+				diff.Binary = true
+			}
+			diff.Header = append(diff.Header, line)
+		}
+	}
+}
+
+// parseHunkHeader parses a hunk header, which looks like "@@ -<old start>,<old lines> +<new start>,<new lines> @@ <section>".
+// The line counts are omitted by git when they're 1.
+func parseHunkHeader(line string) (*Hunk, error) {
+	s := strings.SplitN(strings.TrimPrefix(line, hunkHeaderPrefix), " @@", 2)
+	ranges := strings.Fields(s[0])
+	if len(s) != 2 || len(ranges) != 2 || !strings.HasPrefix(ranges[0], "-") || !strings.HasPrefix(ranges[1], "+") {
+		return nil, fmt.Errorf("could not parse hunk header: %q", line)
+	}
+
+	h := &Hunk{Section: strings.TrimPrefix(s[1], " "), Lines: make([]Line, 0)}
+
+	var err error
+	if h.OldStart, h.OldLines, err = parseHunkRange(ranges[0][1:]); err != nil {
+		return nil, fmt.Errorf("could not parse hunk header: %q: %w", line, err)
+	}
+	if h.NewStart, h.NewLines, err = parseHunkRange(ranges[1][1:]); err != nil {
+		return nil, fmt.Errorf("could not parse hunk header: %q: %w", line, err)
+	}
+
+	return h, nil
+}
+
+// parseHunkRange parses "<start>,<lines>" or just "<start>" (when lines is 1)
+func parseHunkRange(s string) (start, lines int, err error) {
+	startStr, linesStr, found := strings.Cut(s, ",")
+	if start, err = strconv.Atoi(startStr); err != nil {
+		return 0, 0, err
+	}
+	if !found {
+		return start, 1, nil
+	}
+	if lines, err = strconv.Atoi(linesStr); err != nil {
+		return 0, 0, err
+	}
+	return start, lines, nil
+}
@@ -0,0 +1,94 @@
+package gitlog
+
+import "strings"
+
+// RefType is the kind of ref decorating a commit
+type RefType string
+
+const (
+	HeadRef         RefType = "HEAD"
+	LocalBranchRef  RefType = "branch"
+	RemoteBranchRef RefType = "remote"
+	TagRef          RefType = "tag"
+	OtherRef        RefType = "other"
+)
+
+const (
+	headPointerPrefix = "HEAD -> "
+	tagDecorPrefix    = "tag: "
+)
+
+// Ref is a ref that points at a commit, from the decorations git adds with --decorate=full
+type Ref struct {
+	Type RefType
+	// Name is the short name of the ref, such as "main", "origin/main" or "v1.0.0"
+	Name string
+	// FullName is the full name of the ref, such as "refs/heads/main" (or just "HEAD")
+	FullName string
+	// Target is the full name of the branch HEAD points at, for a HEAD ref that isn't detached
+	Target string
+}
+
+// refFromFullName returns a Ref typed according to the namespace of its full name
+func refFromFullName(fullName string) Ref {
+	for _, ns := range []struct {
+		prefix string
+		typ    RefType
+	}{
+		{"refs/heads/", LocalBranchRef},
+		{"refs/remotes/", RemoteBranchRef},
+		{"refs/tags/", TagRef},
+	} {
+		if strings.HasPrefix(fullName, ns.prefix) {
+			return Ref{Type: ns.typ, Name: strings.TrimPrefix(fullName, ns.prefix), FullName: fullName}
+		}
+	}
+
+	if fullName == "HEAD" {
+		return Ref{Type: HeadRef, Name: fullName, FullName: fullName}
+	}
+
+	return Ref{Type: OtherRef, Name: strings.TrimPrefix(fullName, "refs/"), FullName: fullName}
+}
+
+// parseRefs parses the output of the %D placeholder (with --decorate=full), which looks like
+// "HEAD -> refs/heads/main, tag: refs/tags/v1.0.0, refs/remotes/origin/main".
+// Ref names can't contain spaces, so splitting on ", " is safe.
+func parseRefs(s string) []Ref {
+	refs := make([]Ref, 0)
+	if s == "" {
+		return refs
+	}
+
+	for _, decoration := range strings.Split(s, ", ") {
+		switch {
+		case strings.HasPrefix(decoration, headPointerPrefix):
+			target := strings.TrimPrefix(decoration, headPointerPrefix)
+			refs = append(refs, Ref{Type: HeadRef, Name: "HEAD", FullName: "HEAD", Target: target}, refFromFullName(target))
+		case strings.HasPrefix(decoration, tagDecorPrefix):
+			refs = append(refs, refFromFullName(strings.TrimPrefix(decoration, tagDecorPrefix)))
+		default:
+			refs = append(refs, refFromFullName(decoration))
+		}
+	}
+
+	return refs
+}
+
+// formatRefs is the inverse of parseRefs
+func formatRefs(refs []Ref) string {
+	var s []string
+	for n := 0; n < len(refs); n++ {
+		ref := refs[n]
+		switch {
+		case ref.Type == HeadRef && ref.Target != "":
+			s = append(s, headPointerPrefix+ref.Target)
+			n++ // skip the branch HEAD points at, it's part of the same decoration
+		case ref.Type == TagRef:
+			s = append(s, tagDecorPrefix+ref.FullName)
+		default:
+			s = append(s, ref.FullName)
+		}
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,53 @@
+package gitlog
+
+// SignatureStatus is the result of verifying a commit signature, as reported by the %G? placeholder
+// See here: https://git-scm.com/docs/pretty-formats#Documentation/pretty-formats.txt-emGem
+type SignatureStatus string
+
+const (
+	GoodSignature            SignatureStatus = "G"
+	BadSignature             SignatureStatus = "B"
+	UnknownValiditySignature SignatureStatus = "U"
+	ExpiredSignature         SignatureStatus = "X"
+	ExpiredKeySignature      SignatureStatus = "Y"
+	RevokedKeySignature      SignatureStatus = "R"
+	UncheckableSignature     SignatureStatus = "E"
+	NoSignature              SignatureStatus = "N"
+)
+
+// signaturePlaceholders are the format placeholders used when WithSignatures is set
+var signaturePlaceholders = []string{"%G?", "%GS", "%GK", "%GF", "%GT"}
+
+// Signature is the result of verifying a commit's GPG (or SSH) signature
+type Signature struct {
+	Status SignatureStatus
+	// Signer is the name of the signer (the principal, for SSH signatures)
+	Signer string
+	// Key is the key used to sign the commit, and Fingerprint is its fingerprint
+	Key         string
+	Fingerprint string
+	// TrustLevel is the trust level of the key: undefined, never, marginal, fully or ultimate
+	TrustLevel string
+}
+
+// Signed reports whether the commit has a signature at all, whether or not it could be verified
+func (s *Signature) Signed() bool {
+	return s.Status != NoSignature && s.Status != ""
+}
+
+// Verified reports whether the signature is good. Note that a good signature made by
+// a key of unknown validity (UnknownValiditySignature) is not considered verified.
+func (s *Signature) Verified() bool {
+	return s.Status == GoodSignature
+}
+
+// signatureFromFields returns a *Signature from the values of signaturePlaceholders
+func signatureFromFields(status, signer, key, fingerprint, trustLevel string) *Signature {
+	return &Signature{
+		Status:      SignatureStatus(status),
+		Signer:      signer,
+		Key:         key,
+		Fingerprint: fingerprint,
+		TrustLevel:  trustLevel,
+	}
+}
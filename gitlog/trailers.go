@@ -0,0 +1,55 @@
+package gitlog
+
+import "strings"
+
+// trailersPlaceholder asks git to parse the trailers of a commit message using its own rules
+// (including trailer.separators), with the same result as `git interpret-trailers --parse`.
+// Trailers are separated by a unit separator, and keys from values by a group separator.
+const trailersPlaceholder = "%(trailers:only,unfold,separator=%x1f,key_value_separator=%x1d)"
+
+const (
+	trailerSeparator         = "\x1f"
+	trailerKeyValueSeparator = "\x1d"
+)
+
+// Trailer is a single key/value trailer from a commit message, such as "Signed-off-by: Someone <someone@example.com>".
+// Values that span multiple lines in the message are unfolded into a single line.
+type Trailer struct {
+	Key   string
+	Value string
+}
+
+// TrailerValues returns the values of all the trailers with the given key, which is matched case-insensitively
+func (c *Commit) TrailerValues(key string) []string {
+	var values []string
+	for _, trailer := range c.Trailers {
+		if strings.EqualFold(trailer.Key, key) {
+			values = append(values, trailer.Value)
+		}
+	}
+	return values
+}
+
+// parseTrailers parses the output of trailersPlaceholder
+func parseTrailers(s string) []Trailer {
+	trailers := make([]Trailer, 0)
+	if s == "" {
+		return trailers
+	}
+
+	for _, t := range strings.Split(s, trailerSeparator) {
+		key, value, _ := strings.Cut(t, trailerKeyValueSeparator)
+		trailers = append(trailers, Trailer{Key: key, Value: value})
+	}
+
+	return trailers
+}
+
+// formatTrailers is the inverse of parseTrailers
+func formatTrailers(trailers []Trailer) string {
+	s := make([]string, len(trailers))
+	for n, trailer := range trailers {
+		s[n] = trailer.Key + trailerKeyValueSeparator + trailer.Value
+	}
+	return strings.Join(s, trailerSeparator)
+}
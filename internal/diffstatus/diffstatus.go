@@ -0,0 +1,24 @@
+// Package diffstatus defines the status letters git uses for changed files in diff output,
+// which gitlog and lstree both report. See here: https://git-scm.com/docs/git-diff#_raw_output_format
+package diffstatus
+
+// ChangeType is the status of a changed file
+type ChangeType byte
+
+const (
+	Added       ChangeType = 'A'
+	Copied      ChangeType = 'C'
+	Deleted     ChangeType = 'D'
+	Modified    ChangeType = 'M'
+	Renamed     ChangeType = 'R'
+	TypeChanged ChangeType = 'T'
+	Unmerged    ChangeType = 'U'
+	Unknown     ChangeType = 'X'
+)
+
+func (c ChangeType) String() string {
+	if c == 0 {
+		return ""
+	}
+	return string(rune(c))
+}
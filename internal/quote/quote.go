@@ -0,0 +1,77 @@
+// Package quote decodes and encodes paths in the C-style quoted form git prints them in.
+// Git quotes a path when it contains double quotes, backslashes, control characters or,
+// unless core.quotePath is false, bytes outside of ASCII, which it escapes in octal.
+// See here: https://git-scm.com/docs/git-config#Documentation/git-config.txt-corequotePath
+package quote
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Unquote returns the path s decodes to. Paths that aren't quoted are returned as they are.
+func Unquote(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' {
+		return s, nil
+	}
+
+	// the escapes git uses (\a \b \t \n \v \f \r \" \\ and 3-digit octal bytes) are a subset of Go's
+	unquoted, err := strconv.Unquote(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid quoted path %s: %w", s, err)
+	}
+
+	return unquoted, nil
+}
+
+// Quote returns the path s the way git prints it with core.quotePath set to true (the default),
+// which is quoted only if it contains bytes that need escaping.
+func Quote(s string) string {
+	if !needsQuoting(s) {
+		return s
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\v':
+			b.WriteString(`\v`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			if c < 0x20 || c >= 0x7f {
+				fmt.Fprintf(&b, `\%03o`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+
+	return b.String()
+}
+
+// needsQuoting returns true if s contains control characters, double quotes, backslashes or bytes outside of ASCII
+func needsQuoting(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c >= 0x7f || c == '"' || c == '\\' {
+			return true
+		}
+	}
+	return false
+}
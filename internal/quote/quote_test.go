@@ -0,0 +1,50 @@
+package quote
+
+import "testing"
+
+func TestUnquote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`plain.txt`, "plain.txt"},
+		{`with space.txt`, "with space.txt"},
+		{`"\303\251 \"q\".txt"`, "é \"q\".txt"},
+		{`"tab\there\\back\nslash"`, "tab\there\\back\nslash"},
+	}
+
+	for _, test := range tests {
+		got, err := Unquote(test.in)
+		if err != nil {
+			t.Fatalf("%s: %v", test.in, err)
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.in, got, test.want)
+		}
+	}
+
+	if _, err := Unquote(`"unterminated`); err == nil {
+		t.Errorf("expected an error for an invalid quoted path")
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain.txt", `plain.txt`},
+		{"with space.txt", `with space.txt`},
+		{"é \"q\".txt", `"\303\251 \"q\".txt"`},
+		{"tab\there\\back\nslash\x01\x7f", `"tab\there\\back\nslash\001\177"`},
+	}
+
+	for _, test := range tests {
+		got := Quote(test.in)
+		if got != test.want {
+			t.Errorf("%q: got %s, want %s", test.in, got, test.want)
+		}
+
+		if unquoted, err := Unquote(got); err != nil || unquoted != test.in {
+			t.Errorf("%q: expected Unquote to round-trip, got %q (%v)", test.in, unquoted, err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Package testrepo builds small git repositories with deterministic commit hashes, for use in tests.
+// Together with the record/replay executors in the runner package, it lets parser tests run offline:
+// run the tests with -record once to save fixtures to testdata/, and they're replayed from then on.
+package testrepo
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mergestat/gitutils/runner"
+)
+
+var record = flag.Bool("record", false, "record the fixtures in testdata/ from a synthetic repo, rather than replaying them")
+
+// Commit describes a commit to make in a synthetic repo
+type Commit struct {
+	// Files maps paths to their new contents, an empty string deletes the file
+	Files   map[string]string
+	Message string
+}
+
+// Fixtures returns a *runner.Runner that replays the fixtures in testdata/, along with a (meaningless) repo path.
+// If the -record flag is set, build is called to create a repo, and the returned runner records fixtures from it.
+func Fixtures(t testing.TB, build func(t testing.TB) string) (*runner.Runner, string) {
+	t.Helper()
+
+	if !*record {
+		return runner.New(runner.WithExecutor(runner.NewReplayer("testdata"))), "testdata"
+	}
+
+	return runner.New(runner.WithExecutor(runner.NewRecorder("testdata", nil))), build(t)
+}
+
+// Env returns an environment isolated from user and system git config, with fixed
+// identities and dates, so that commits made with it have deterministic hashes.
+func Env(home string, n int) []string {
+	date := fmt.Sprintf("2022-01-%02dT12:00:00+01:00", n+1)
+	return []string{
+		"HOME=" + home,
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_AUTHOR_NAME=Fixture Author", "GIT_AUTHOR_EMAIL=author@example.com", "GIT_AUTHOR_DATE=" + date,
+		"GIT_COMMITTER_NAME=Fixture Committer", "GIT_COMMITTER_EMAIL=committer@example.com", "GIT_COMMITTER_DATE=" + date,
+	}
+}
+
+// Git runs git in dir with the n-th deterministic environment, failing the test on error
+func Git(t testing.TB, dir string, n int, args ...string) string {
+	t.Helper()
+
+	r := runner.New(runner.WithEnv(Env(dir, n)))
+	out, err := r.Output(context.Background(), r.Command(dir, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(out)
+}
+
+// New creates a repo in a temporary directory and makes each of the commits in it, in order
+func New(t testing.TB, commits []Commit, initArgs ...string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	Git(t, dir, 0, append([]string{"init", "--quiet", "--initial-branch=main"}, initArgs...)...)
+
+	for n, c := range commits {
+		for path, contents := range c.Files {
+			path = filepath.Join(dir, path)
+			if contents == "" {
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		Git(t, dir, n, "add", "--all")
+		Git(t, dir, n, "commit", "--quiet", "--allow-empty", "--message", c.Message)
+	}
+
+	return dir
+}
@@ -1,16 +1,28 @@
+// Package lsfiles shells out to git ls-files https://git-scm.com/docs/git-ls-files
 package lsfiles
 
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mergestat/gitutils/internal/quote"
+	"github.com/mergestat/gitutils/lstree"
+	"github.com/mergestat/gitutils/runner"
 )
 
 type execOptions struct {
 	Files            string
 	NoEmptyDirectory bool
+	Stage            bool
+	Debug            bool
+	Format           string
+	Runner           *runner.Runner
 }
 
 type Option func(o *execOptions)
@@ -31,21 +43,230 @@ func WithNoEmptyDirectory(NoEmptyDirectory bool) Option {
 	}
 }
 
+// WithStage corresponds to the `--stage` flag, which includes the mode, object hash and stage number of entries
+// See here: https://git-scm.com/docs/git-ls-files#Documentation/git-ls-files.txt---stage
+func WithStage(stage bool) Option {
+	return func(o *execOptions) {
+		o.Stage = stage
+	}
+}
+
+// WithDebug corresponds to the `--debug` flag, which includes the stat data cached in the index in Entry.Stat
+// See here: https://git-scm.com/docs/git-ls-files#Documentation/git-ls-files.txt---debug
+func WithDebug(debug bool) Option {
+	return func(o *execOptions) {
+		o.Debug = debug
+	}
+}
+
+// WithFormat corresponds to the `--format` flag, and sets Entry.Formatted to the output of format for each entry,
+// such as "%(eolinfo:index) %(eolattr)". The mode, object hash and stage of entries are set as with WithStage,
+// which can't be combined with it. The format mustn't include NUL bytes (%x00).
+// See here: https://git-scm.com/docs/git-ls-files#_output
+func WithFormat(format string) Option {
+	return func(o *execOptions) {
+		o.Format = format
+	}
+}
+
+// WithRunner sets the *runner.Runner used to invoke git
+func WithRunner(r *runner.Runner) Option {
+	return func(o *execOptions) {
+		o.Runner = r
+	}
+}
+
 type iterator struct {
-	scanner *bufio.Scanner
+	reader *bufio.Reader
+	proc   runner.Process
+	stage  bool
+	format bool
+	debug  bool
+}
+
+// Entry is a single entry of the index
+type Entry struct {
+	// Mode, Hash and Stage are only set with WithStage
+	Mode lstree.Mode
+	Hash string
+	// Stage is 0 for merged entries. Entries with a merge conflict have a stage of 1 (the common
+	// ancestor), 2 (ours) or 3 (theirs), see https://git-scm.com/docs/git-read-tree#_3_way_merge
+	Stage int
+	Path  string
+	// Stat is the stat data of the file cached in the index, it's only set with WithDebug
+	Stat *Stat
+	// Formatted is the entry formatted with the format set by WithFormat
+	Formatted string
+
+	// stage is set if the entry was listed with WithStage, so that String() includes the mode, hash and stage
+	stage bool
+}
+
+// Stat is the stat data git caches in the index to tell if a file changed, as printed by --debug.
+// Values are truncated to 32 bits, as they're stored in the index.
+// See here: https://git-scm.com/docs/index-format
+type Stat struct {
+	CTime time.Time
+	MTime time.Time
+	Dev   uint32
+	Ino   uint32
+	UID   uint32
+	GID   uint32
+	Size  uint32
+	// Flags are the flags of the index entry, which include its stage in bits 12 and 13
+	Flags uint32
+}
+
+// String returns an *Entry in the same format as a single line of the default git ls-files
+// output (or --stage output, if it was listed with WithStage), quoting the path as git does.
+func (e *Entry) String() string {
+	if !e.stage {
+		return quote.Quote(e.Path)
+	}
+	return fmt.Sprintf("%s %s %d\t%s", e.Mode, e.Hash, e.Stage, quote.Quote(e.Path))
+}
+
+// stageFormat is the --format equivalent of the output of --stage
+const stageFormat = "%(objectmode) %(objectname) %(stage)%x09%(path)"
+
+// entryFromOutputEntry parses a single NUL-terminated entry of git ls-files -z output (without the NUL),
+// which looks like "<mode> <object> <stage>\t<path>" with --stage, and is only the path otherwise
+func entryFromOutputEntry(entry string, stage bool) (*Entry, error) {
+	if !stage {
+		return &Entry{Path: entry}, nil
+	}
+
+	// the path is the only part that can contain tabs
+	meta, path, ok := strings.Cut(entry, "\t")
+	fields := strings.Fields(meta)
+	if !ok || len(fields) != 3 {
+		return nil, fmt.Errorf("unexpected git ls-files output: %q", entry)
+	}
+
+	n, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("unexpected git ls-files stage: %w", err)
+	}
+
+	return &Entry{Mode: lstree.Mode(fields[0]), Hash: fields[1], Stage: n, Path: path, stage: true}, nil
 }
 
-// Next moves the iterator and returns the next file (or error).
+// readStat parses the stat data --debug prints after an entry, which looks like this
+// (with each line indented by two spaces, the values of a line separated by a tab, and the flags in hexadecimal):
+//
+//	ctime: 1650000000:0
+//	mtime: 1650000000:0
+//	dev: 2049	ino: 1234
+//	uid: 1000	gid: 1000
+//	size: 10	flags: 0
+func (i *iterator) readStat() (*Stat, error) {
+	stat := &Stat{}
+	fields := map[string]*uint32{"dev": &stat.Dev, "ino": &stat.Ino, "uid": &stat.UID, "gid": &stat.GID, "size": &stat.Size, "flags": &stat.Flags}
+
+	for n := 0; n < 5; n++ {
+		line, err := i.reader.ReadString('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = fmt.Errorf("unexpected end of git ls-files output: %w", io.ErrUnexpectedEOF)
+			}
+			return nil, err
+		}
+
+		for _, field := range strings.Split(strings.TrimSpace(line), "\t") {
+			key, value, ok := strings.Cut(field, ": ")
+			if !ok {
+				return nil, fmt.Errorf("unexpected git ls-files --debug output: %q", line)
+			}
+
+			switch key {
+			case "ctime", "mtime":
+				sec, nsec, ok := strings.Cut(value, ":")
+				s, err := strconv.ParseInt(sec, 10, 64)
+				if err != nil || !ok {
+					return nil, fmt.Errorf("unexpected git ls-files --debug %s: %q", key, value)
+				}
+				ns, err := strconv.ParseInt(nsec, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("unexpected git ls-files --debug %s: %q", key, value)
+				}
+				if key == "ctime" {
+					stat.CTime = time.Unix(s, ns)
+				} else {
+					stat.MTime = time.Unix(s, ns)
+				}
+			default:
+				p, known := fields[key]
+				if !known {
+					return nil, fmt.Errorf("unexpected git ls-files --debug output: %q", line)
+				}
+				// git prints the flags in hexadecimal
+				base := 10
+				if key == "flags" {
+					base = 16
+				}
+				v, err := strconv.ParseUint(value, base, 32)
+				if err != nil {
+					return nil, fmt.Errorf("unexpected git ls-files --debug %s: %q", key, value)
+				}
+				*p = uint32(v)
+			}
+		}
+	}
+
+	return stat, nil
+}
+
+// Next moves the iterator and returns the next entry (or error).
 // Iteration is complete when the error returned is io.EOF
-func (i *iterator) Next() (string, error) {
-	if next := i.scanner.Scan(); !next {
-		if err := i.scanner.Err(); err != nil {
-			return "", err
+func (i *iterator) Next() (*Entry, error) {
+	entry, err := i.reader.ReadString(0)
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			_ = i.proc.Close()
+			return nil, err
+		}
+		if err := i.proc.Wait(); err != nil {
+			return nil, err
+		}
+		if entry != "" {
+			return nil, fmt.Errorf("unexpected end of git ls-files output: %w", io.ErrUnexpectedEOF)
+		}
+		return nil, io.EOF
+	}
+
+	e, err := entryFromOutputEntry(strings.TrimSuffix(entry, "\x00"), i.stage)
+	if err != nil {
+		_ = i.proc.Close()
+		return nil, err
+	}
+
+	if i.format {
+		// the formatted entry follows the same fields as --stage, in a separate NUL-terminated token
+		formatted, err := i.reader.ReadString(0)
+		if err != nil {
+			_ = i.proc.Close()
+			if errors.Is(err, io.EOF) {
+				err = fmt.Errorf("unexpected end of git ls-files output: %w", io.ErrUnexpectedEOF)
+			}
+			return nil, err
+		}
+		e.Formatted = strings.TrimSuffix(formatted, "\x00")
+	}
+
+	if i.debug {
+		if e.Stat, err = i.readStat(); err != nil {
+			_ = i.proc.Close()
+			return nil, err
 		}
-		return "", io.EOF
-	} else {
-		return i.scanner.Text(), nil
 	}
+
+	return e, nil
+}
+
+// Close stops the underlying git process if it's still running and releases its resources.
+// It's safe to call Close more than once, and after iteration has completed.
+func (i *iterator) Close() error {
+	return i.proc.Close()
 }
 
 // Exec runs `git ls-files` using the os/exec standard library package.
@@ -57,36 +278,45 @@ func Exec(ctx context.Context, repoPath string, options ...Option) (*iterator, e
 		option(o)
 	}
 
-	gitPath, err := exec.LookPath("git")
-	if err != nil {
-		return nil, fmt.Errorf("could not find git: %w", err)
+	r := o.Runner
+	if r == nil {
+		r = runner.New()
 	}
 
-	args := []string{"ls-files"}
+	args := []string{"ls-files", "-z"}
 
 	if o.NoEmptyDirectory {
 		args = append(args, "--no-empty-directory")
 	}
 
+	switch {
+	case o.Format != "":
+		// --format can't be combined with --stage, so the same fields are included in the format
+		args = append(args, "--format="+stageFormat+"%x00"+o.Format)
+	case o.Stage:
+		args = append(args, "--stage")
+	}
+
+	if o.Debug {
+		args = append(args, "--debug")
+	}
+
 	// NOTE: this has to be the last argument in the list
 	if o.Files != "" {
 		args = append(args, o.Files)
 	}
 
-	cmd := exec.CommandContext(ctx, gitPath, args...)
-	cmd.Dir = repoPath
-
-	stdout, err := cmd.StdoutPipe()
+	proc, err := r.Start(ctx, r.Command(repoPath, args...))
 	if err != nil {
 		return nil, err
 	}
 
-	if err := cmd.Start(); err != nil {
-		return nil, err
-	}
-
 	iter := &iterator{
-		scanner: bufio.NewScanner(stdout),
+		reader: bufio.NewReader(proc.Stdout()),
+		proc:   proc,
+		stage:  o.Stage || o.Format != "",
+		format: o.Format != "",
+		debug:  o.Debug,
 	}
 
 	return iter, nil
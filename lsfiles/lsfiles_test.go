@@ -11,7 +11,10 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/mergestat/gitutils/internal/testrepo"
 	"github.com/mergestat/gitutils/lsfiles"
+	"github.com/mergestat/gitutils/lstree"
+	"github.com/mergestat/gitutils/runner"
 )
 
 var (
@@ -37,14 +40,14 @@ func TestBasicOK(t *testing.T) {
 
 	var got strings.Builder
 	for {
-		file, err := iter.Next()
+		entry, err := iter.Next()
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				break
 			}
 			t.Fatal(err)
 		}
-		got.WriteString(file + "\n")
+		got.WriteString(entry.String() + "\n")
 	}
 
 	gitPath, err := exec.LookPath("git")
@@ -69,3 +72,146 @@ func TestBasicOK(t *testing.T) {
 		t.Fatal("mismatch")
 	}
 }
+
+func newFixtureRepo(t testing.TB) string {
+	dir := testrepo.New(t, []testrepo.Commit{
+		{Files: map[string]string{
+			"README.md":     "# readme\n",
+			"café.txt":      "coffee\n",
+			"tab\there.txt": "tab\n",
+			"new\nline.txt": "newline\n",
+			"src/main.go":   "package main\n",
+		}, Message: "initial commit"},
+	})
+
+	// put the three stages of a merge conflict in the index
+	blob := strings.TrimSpace(testrepo.Git(t, dir, 0, "rev-parse", "HEAD:README.md"))
+	r := runner.New(runner.WithEnv(testrepo.Env(dir, 0)))
+	c := r.Command(dir, "update-index", "--index-info")
+	c.Stdin = strings.NewReader("100644 " + blob + " 1\tconflict.txt\n100644 " + blob + " 2\tconflict.txt\n100755 " + blob + " 3\tconflict.txt\n")
+	if _, err := r.Output(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func listAll(t *testing.T, path string, options ...lsfiles.Option) []*lsfiles.Entry {
+	t.Helper()
+
+	iter, err := lsfiles.Exec(context.Background(), path, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = iter.Close() }()
+
+	var entries []*lsfiles.Entry
+	for {
+		e, err := iter.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return entries
+			}
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+}
+
+func TestFixtureOddPaths(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newFixtureRepo)
+
+	var got []string
+	for _, e := range listAll(t, path, lsfiles.WithRunner(r)) {
+		if e.Mode != "" || e.Hash != "" || e.Stat != nil {
+			t.Errorf("unexpected details of %q without WithStage or WithDebug: %+v", e.Path, e)
+		}
+		got = append(got, e.String())
+	}
+
+	// the conflicted file is listed once per stage
+	want := []string{"README.md", `"caf\303\251.txt"`, "conflict.txt", "conflict.txt", "conflict.txt", `"new\nline.txt"`, "src/main.go", `"tab\there.txt"`}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFixtureStage(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newFixtureRepo)
+
+	entries := listAll(t, path, lsfiles.WithRunner(r), lsfiles.WithStage(true), lsfiles.WithFiles("conflict.txt"))
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+
+	for n, e := range entries {
+		if e.Stage != n+1 || e.Path != "conflict.txt" || len(e.Hash) != 40 {
+			t.Errorf("unexpected entry: %+v", e)
+		}
+	}
+
+	if entries[2].Mode != lstree.ExecutableFile || !entries[2].Mode.IsExecutable() {
+		t.Errorf("expected the mode of stage 3 to be executable, got %s", entries[2].Mode)
+	}
+
+	want := "100644 " + entries[0].Hash + " 1\tconflict.txt"
+	if got := entries[0].String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	entries = listAll(t, path, lsfiles.WithRunner(r), lsfiles.WithStage(true), lsfiles.WithFiles("tab\there.txt"))
+	if len(entries) != 1 || entries[0].Path != "tab\there.txt" || entries[0].Stage != 0 || entries[0].Mode != lstree.NormalFile {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+}
+
+func TestFixtureDebug(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newFixtureRepo)
+
+	entries := listAll(t, path, lsfiles.WithRunner(r), lsfiles.WithStage(true), lsfiles.WithDebug(true))
+	if len(entries) != 8 {
+		t.Fatalf("expected 8 entries, got %d", len(entries))
+	}
+
+	for _, e := range entries {
+		if e.Stat == nil || e.Hash == "" {
+			t.Fatalf("expected stat data and a hash for %q", e.Path)
+		}
+
+		switch e.Path {
+		case "src/main.go":
+			if e.Stat.Size != uint32(len("package main\n")) || e.Stat.MTime.IsZero() || e.Stat.CTime.IsZero() || e.Stat.Ino == 0 {
+				t.Errorf("unexpected stat data for %q: %+v", e.Path, e.Stat)
+			}
+		case "conflict.txt":
+			// entries added with update-index --index-info have no stat data, and their stage in the flags
+			if e.Stat.Size != 0 || e.Stat.MTime.Unix() != 0 || e.Stat.Flags>>12&3 != uint32(e.Stage) {
+				t.Errorf("unexpected stat data for %q: %+v", e.Path, e.Stat)
+			}
+		}
+	}
+}
+
+func TestFixtureFormat(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newFixtureRepo)
+
+	staged := listAll(t, path, lsfiles.WithRunner(r), lsfiles.WithStage(true))
+	formatted := listAll(t, path, lsfiles.WithRunner(r), lsfiles.WithFormat("%(eolinfo:index)|%(eolattr)|%(path)"), lsfiles.WithDebug(true))
+	if len(formatted) != len(staged) {
+		t.Fatalf("got %d entries, want %d", len(formatted), len(staged))
+	}
+
+	for n, e := range formatted {
+		if got, want := e.String(), staged[n].String(); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		if want := "lf||" + e.Path; e.Formatted != want {
+			t.Errorf("got formatted entry %q, want %q", e.Formatted, want)
+		}
+
+		if e.Stat == nil {
+			t.Errorf("expected stat data for %q", e.Path)
+		}
+	}
+}
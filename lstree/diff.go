@@ -0,0 +1,177 @@
+package lstree
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/mergestat/gitutils/internal/diffstatus"
+	"github.com/mergestat/gitutils/runner"
+)
+
+// ChangeType is the status of a changed tree entry, as reported by `git diff-tree --raw`
+// See here: https://git-scm.com/docs/git-diff-tree#_raw_output_format
+type ChangeType = diffstatus.ChangeType
+
+const (
+	Added       = diffstatus.Added
+	Copied      = diffstatus.Copied
+	Deleted     = diffstatus.Deleted
+	Modified    = diffstatus.Modified
+	Renamed     = diffstatus.Renamed
+	TypeChanged = diffstatus.TypeChanged
+	Unmerged    = diffstatus.Unmerged
+	Unknown     = diffstatus.Unknown
+)
+
+// Change is a single entry that differs between two trees. For added entries, OldMode is "000000"
+// and OldHash is all zeros, and likewise for NewMode and NewHash of deleted entries.
+type Change struct {
+	OldMode Mode
+	NewMode Mode
+	OldHash string
+	NewHash string
+	Status  ChangeType
+	// Similarity is the similarity index (0-100) of renamed and copied entries
+	Similarity int
+	// OldPath is the path the entry was renamed or copied from, it's empty otherwise
+	OldPath string
+	Path    string
+}
+
+type diffIterator struct {
+	reader *bufio.Reader
+	proc   runner.Process
+}
+
+// token returns the next NUL-terminated token (without the NUL)
+func (i *diffIterator) token() (string, error) {
+	tok, err := i.reader.ReadString(0)
+	if err != nil {
+		if errors.Is(err, io.EOF) && tok != "" {
+			return "", fmt.Errorf("unexpected end of git diff-tree output: %w", io.ErrUnexpectedEOF)
+		}
+		return "", err
+	}
+	return tok[:len(tok)-1], nil
+}
+
+// Next moves the iterator and returns the next change (or error).
+// Iteration is complete when the error returned is io.EOF
+func (i *diffIterator) Next() (*Change, error) {
+	tok, err := i.token()
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			_ = i.proc.Close()
+			return nil, err
+		}
+		if err := i.proc.Wait(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+
+	change, err := changeFromRawEntry(tok)
+	if err != nil {
+		_ = i.proc.Close()
+		return nil, err
+	}
+
+	paths := []*string{&change.Path}
+	if change.Status == Renamed || change.Status == Copied {
+		paths = []*string{&change.OldPath, &change.Path}
+	}
+
+	for _, p := range paths {
+		if *p, err = i.token(); err != nil {
+			_ = i.proc.Close()
+			if errors.Is(err, io.EOF) {
+				err = fmt.Errorf("unexpected end of git diff-tree output: %w", io.ErrUnexpectedEOF)
+			}
+			return nil, err
+		}
+	}
+
+	return change, nil
+}
+
+// Close stops the underlying git process if it's still running and releases its resources.
+// It's safe to call Close more than once, and after iteration has completed.
+func (i *diffIterator) Close() error {
+	return i.proc.Close()
+}
+
+// changeFromRawEntry parses the part of a --raw -z entry before the paths, which looks like
+// ":<old mode> <new mode> <old sha> <new sha> <status>[<score>]"
+func changeFromRawEntry(entry string) (*Change, error) {
+	fields := strings.Fields(strings.TrimPrefix(entry, ":"))
+	if !strings.HasPrefix(entry, ":") || len(fields) != 5 || fields[4] == "" {
+		return nil, fmt.Errorf("unexpected git diff-tree output: %q", entry)
+	}
+
+	change := &Change{
+		OldMode: Mode(fields[0]),
+		NewMode: Mode(fields[1]),
+		OldHash: fields[2],
+		NewHash: fields[3],
+		Status:  ChangeType(fields[4][0]),
+	}
+
+	if score := fields[4][1:]; score != "" {
+		similarity, err := strconv.Atoi(score)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected git diff-tree similarity score %q: %w", score, err)
+		}
+		change.Similarity = similarity
+	}
+
+	return change, nil
+}
+
+// Diff runs `git diff-tree -r -z --raw` to compare two tree-ishes (such as commits), and returns an
+// iterator over the entries that differ. WithFindRenames, WithFindCopies, WithPaths and WithRunner apply.
+// See here: https://git-scm.com/docs/git-diff-tree
+func Diff(ctx context.Context, repoPath, from, to string, options ...Option) (*diffIterator, error) {
+	o := &execOptions{}
+	for _, option := range options {
+		option(o)
+	}
+
+	r := o.Runner
+	if r == nil {
+		r = runner.New()
+	}
+
+	args := []string{"diff-tree", "-r", "-z", "--raw", "--no-commit-id"}
+
+	if o.FindRenames {
+		args = append(args, "-M")
+	}
+
+	if o.FindCopies {
+		args = append(args, "-C")
+	}
+
+	args = append(args, from, to)
+
+	if len(o.Paths) > 0 {
+		args = append(args, "--")
+		args = append(args, o.Paths...)
+	}
+
+	proc, err := r.Start(ctx, r.Command(repoPath, args...))
+	if err != nil {
+		return nil, err
+	}
+
+	iter := &diffIterator{
+		reader: bufio.NewReader(proc.Stdout()),
+		proc:   proc,
+	}
+
+	return iter, nil
+}
@@ -4,12 +4,18 @@ package lstree
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"os/exec"
+	"strconv"
 	"strings"
+
+	"github.com/mergestat/gitutils/internal/quote"
+	"github.com/mergestat/gitutils/runner"
 )
 
+// Mode is the octal file mode of a tree entry, as git prints it. Modes other than the
+// constants below (such as the legacy 100664) are kept as they are.
 type Mode string
 
 const (
@@ -20,8 +26,58 @@ const (
 	Submodule      Mode = "160000"
 )
 
+// file type bits of a mode, see S_IFMT in stat(2)
+const (
+	typeMask      = 0o170000
+	typeDir       = 0o040000
+	typeRegular   = 0o100000
+	typeSymlink   = 0o120000
+	typeSubmodule = 0o160000
+)
+
+// bits returns the numeric value of the mode, or 0 if it isn't a valid octal number
+func (m Mode) bits() uint32 {
+	bits, err := strconv.ParseUint(string(m), 8, 32)
+	if err != nil {
+		return 0
+	}
+	return uint32(bits)
+}
+
+// IsDir returns true if the mode is that of a tree, however it's written (040000 or 40000)
+func (m Mode) IsDir() bool { return m.bits()&typeMask == typeDir }
+
+// IsRegular returns true if the mode is that of a regular file, executable or not
+func (m Mode) IsRegular() bool { return m.bits()&typeMask == typeRegular }
+
+// IsExecutable returns true if the mode is that of a regular file with any executable bit set
+func (m Mode) IsExecutable() bool { return m.IsRegular() && m.bits()&0o111 != 0 }
+
+// IsSymlink returns true if the mode is that of a symbolic link
+func (m Mode) IsSymlink() bool { return m.bits()&typeMask == typeSymlink }
+
+// IsSubmodule returns true if the mode is that of a submodule (a gitlink)
+func (m Mode) IsSubmodule() bool { return m.bits()&typeMask == typeSubmodule }
+
+// Type is the type of the object a tree entry points to. Types other than the constants below are kept as they are.
+type Type string
+
+const (
+	BlobType   Type = "blob"
+	TreeType   Type = "tree"
+	CommitType Type = "commit"
+)
+
 type execOptions struct {
-	Recurse bool
+	Recurse     bool
+	Long        bool
+	FullTree    bool
+	TreesOnly   bool
+	ShowTrees   bool
+	Paths       []string
+	FindRenames bool
+	FindCopies  bool
+	Runner      *runner.Runner
 }
 
 type Option func(o *execOptions)
@@ -34,65 +90,172 @@ func WithRecurse(recurse bool) Option {
 	}
 }
 
+// WithLong corresponds to the --long flag, which includes the size of blobs in Object.Size
+// https://www.git-scm.com/docs/git-ls-tree#Documentation/git-ls-tree.txt---long
+func WithLong(long bool) Option {
+	return func(o *execOptions) {
+		o.Long = long
+	}
+}
+
+// WithFullTree corresponds to the --full-tree flag, which lists the full tree rather than the
+// part of it under the repo path, with paths relative to the root of the repository
+// https://www.git-scm.com/docs/git-ls-tree#Documentation/git-ls-tree.txt---full-tree
+func WithFullTree(fullTree bool) Option {
+	return func(o *execOptions) {
+		o.FullTree = fullTree
+	}
+}
+
+// WithTreesOnly corresponds to the -d flag, which only lists trees
+// https://www.git-scm.com/docs/git-ls-tree#Documentation/git-ls-tree.txt--d
+func WithTreesOnly(treesOnly bool) Option {
+	return func(o *execOptions) {
+		o.TreesOnly = treesOnly
+	}
+}
+
+// WithShowTrees corresponds to the -t flag, which lists trees even when recursing into them
+// https://www.git-scm.com/docs/git-ls-tree#Documentation/git-ls-tree.txt--t
+func WithShowTrees(showTrees bool) Option {
+	return func(o *execOptions) {
+		o.ShowTrees = showTrees
+	}
+}
+
+// WithPaths restricts the listing to the given paths (or patterns), which are passed after --
+// https://www.git-scm.com/docs/git-ls-tree#Documentation/git-ls-tree.txt-ltpathgt82308203
+func WithPaths(paths []string) Option {
+	return func(o *execOptions) {
+		o.Paths = paths
+	}
+}
+
+// WithFindRenames sets the -M flag of Diff, to detect renamed entries
+// https://git-scm.com/docs/git-diff-tree#Documentation/git-diff-tree.txt--Mltngt
+func WithFindRenames(findRenames bool) Option {
+	return func(o *execOptions) {
+		o.FindRenames = findRenames
+	}
+}
+
+// WithFindCopies sets the -C flag of Diff, to detect copied entries
+// https://git-scm.com/docs/git-diff-tree#Documentation/git-diff-tree.txt--Cltngt
+func WithFindCopies(findCopies bool) Option {
+	return func(o *execOptions) {
+		o.FindCopies = findCopies
+	}
+}
+
+// WithRunner sets the *runner.Runner used to invoke git
+func WithRunner(r *runner.Runner) Option {
+	return func(o *execOptions) {
+		o.Runner = r
+	}
+}
+
 type iterator struct {
-	scanner *bufio.Scanner
+	reader *bufio.Reader
+	proc   runner.Process
+	long   bool
 }
 
 type Object struct {
-	Mode string
-	Type string
+	Mode Mode
+	Type Type
 	Hash string
+	// Size is the size of a blob in bytes, which is only set with WithLong. It's -1 for trees and submodules.
+	Size int64
 	Path string
-}
 
-// modeFromString returns a Mode from a string representation of a git object mode.
-func modeFromString(s string) Mode {
-	switch s {
-	case "100644":
-		return NormalFile
-	case "100755":
-		return ExecutableFile
-	case "120000":
-		return SymbolicLink
-	case "040000":
-		return Tree
-	case "160000":
-		return Submodule
-	default:
-		return ""
-	}
+	// long is set if the object was listed with WithLong, so that String() includes the size
+	long bool
 }
 
-// objectFromOutputLine parses a single line in the default git ls-tree output format
+// objectFromOutputEntry parses a single NUL-terminated entry of git ls-tree -z output (without the NUL)
 // and returns an Object struct. See here: https://www.git-scm.com/docs/git-ls-tree#_output_format
-func objectFromOutputLine(line string) *Object {
-	s := strings.SplitN(line, " ", 3)
-	s2 := strings.Split(s[2], "\t")
-	return &Object{
-		Mode: string(modeFromString(s[0])),
-		Type: s[1],
-		Hash: s2[0],
-		Path: s2[1],
+func objectFromOutputEntry(entry string, long bool) (*Object, error) {
+	// the path is the only part that can contain tabs
+	meta, path, ok := strings.Cut(entry, "\t")
+	if !ok {
+		return nil, fmt.Errorf("unexpected git ls-tree output: %q", entry)
+	}
+
+	fields := strings.Fields(meta)
+	if (long && len(fields) != 4) || (!long && len(fields) != 3) {
+		return nil, fmt.Errorf("unexpected git ls-tree output: %q", entry)
+	}
+
+	o := &Object{
+		Mode: Mode(fields[0]),
+		Type: Type(fields[1]),
+		Hash: fields[2],
+		Path: path,
+		long: long,
 	}
+
+	if long {
+		if fields[3] == "-" {
+			o.Size = -1
+		} else {
+			size, err := strconv.ParseInt(fields[3], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected git ls-tree object size: %w", err)
+			}
+			o.Size = size
+		}
+	}
+
+	return o, nil
 }
 
 // String returns an *Object in the same format as a single line of the default
-// git ls-tree output. See here: https://www.git-scm.com/docs/git-ls-tree#_output_format
+// git ls-tree output (or --long output, if it was listed with WithLong), quoting the path as git does.
+// See here: https://www.git-scm.com/docs/git-ls-tree#_output_format
 func (o *Object) String() string {
-	return fmt.Sprintf("%s %s %s\t%s", o.Mode, o.Type, o.Hash, o.Path)
+	if !o.long {
+		return fmt.Sprintf("%s %s %s\t%s", o.Mode, o.Type, o.Hash, quote.Quote(o.Path))
+	}
+
+	size := "-"
+	if o.Size >= 0 {
+		size = strconv.FormatInt(o.Size, 10)
+	}
+
+	return fmt.Sprintf("%s %s %s %7s\t%s", o.Mode, o.Type, o.Hash, size, quote.Quote(o.Path))
 }
 
 // Next moves the iterator and returns the next object (or error).
 // Iteration is complete when the error returned is io.EOF
 func (i *iterator) Next() (*Object, error) {
-	if next := i.scanner.Scan(); !next {
-		if err := i.scanner.Err(); err != nil {
+	entry, err := i.reader.ReadString(0)
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			_ = i.proc.Close()
+			return nil, err
+		}
+		if err := i.proc.Wait(); err != nil {
 			return nil, err
 		}
+		if entry != "" {
+			return nil, fmt.Errorf("unexpected end of git ls-tree output: %w", io.ErrUnexpectedEOF)
+		}
 		return nil, io.EOF
-	} else {
-		return objectFromOutputLine(i.scanner.Text()), nil
 	}
+
+	o, err := objectFromOutputEntry(strings.TrimSuffix(entry, "\x00"), i.long)
+	if err != nil {
+		_ = i.proc.Close()
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// Close stops the underlying git process if it's still running and releases its resources.
+// It's safe to call Close more than once, and after iteration has completed.
+func (i *iterator) Close() error {
+	return i.proc.Close()
 }
 
 // Exec runs `git ls-tree` using the os/exec standard library package.
@@ -104,33 +267,49 @@ func Exec(ctx context.Context, repoPath, treeish string, options ...Option) (*it
 		option(o)
 	}
 
-	gitPath, err := exec.LookPath("git")
-	if err != nil {
-		return nil, fmt.Errorf("could not find git: %w", err)
+	r := o.Runner
+	if r == nil {
+		r = runner.New()
 	}
 
-	args := []string{"ls-tree"}
+	args := []string{"ls-tree", "-z"}
 
 	if o.Recurse {
 		args = append(args, "-r")
 	}
 
-	args = append(args, treeish)
+	if o.Long {
+		args = append(args, "--long")
+	}
 
-	cmd := exec.CommandContext(ctx, gitPath, args...)
-	cmd.Dir = repoPath
+	if o.FullTree {
+		args = append(args, "--full-tree")
+	}
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, err
+	if o.TreesOnly {
+		args = append(args, "-d")
+	}
+
+	if o.ShowTrees {
+		args = append(args, "-t")
 	}
 
-	if err := cmd.Start(); err != nil {
+	args = append(args, treeish)
+
+	if len(o.Paths) > 0 {
+		args = append(args, "--")
+		args = append(args, o.Paths...)
+	}
+
+	proc, err := r.Start(ctx, r.Command(repoPath, args...))
+	if err != nil {
 		return nil, err
 	}
 
 	iter := &iterator{
-		scanner: bufio.NewScanner(stdout),
+		reader: bufio.NewReader(proc.Stdout()),
+		proc:   proc,
+		long:   o.Long,
 	}
 
 	return iter, nil
@@ -11,7 +11,9 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/mergestat/gitutils/internal/testrepo"
 	"github.com/mergestat/gitutils/lstree"
+	"github.com/mergestat/gitutils/runner"
 )
 
 var (
@@ -69,3 +71,323 @@ func TestBasicOK(t *testing.T) {
 		t.Fatal("mismatch")
 	}
 }
+
+func newFixtureRepo(t testing.TB) string {
+	dir := testrepo.New(t, []testrepo.Commit{
+		{Files: map[string]string{
+			"README.md":          "# readme\n",
+			"café.txt":           "coffee\n",
+			"tab\there.txt":      "tab\n",
+			"new\nline.txt":      "newline\n",
+			"src/main.go":        "package main\n",
+			"src/pkg/pkg.go":     "package pkg\n",
+			"vendor/lib/lib.go":  "package lib\n",
+			"scripts/run.sh":     "#!/bin/sh\n",
+			"docs/with space.md": "docs\n",
+		}, Message: "initial commit"},
+	})
+	testrepo.Git(t, dir, 1, "update-index", "--chmod=+x", "scripts/run.sh")
+	testrepo.Git(t, dir, 1, "commit", "--quiet", "--message", "make run.sh executable")
+	return dir
+}
+
+// listAll returns all the objects listed by lstree.Exec
+func listAll(t *testing.T, repoPath, treeish string, options ...lstree.Option) []*lstree.Object {
+	t.Helper()
+
+	iter, err := lstree.Exec(context.Background(), repoPath, treeish, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var objects []*lstree.Object
+	for {
+		o, err := iter.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatal(err)
+		}
+		objects = append(objects, o)
+	}
+
+	return objects
+}
+
+func TestFixtureOddPaths(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newFixtureRepo)
+
+	objects := listAll(t, path, "HEAD", lstree.WithRunner(r), lstree.WithRecurse(true), lstree.WithLong(true))
+
+	paths := make(map[string]*lstree.Object)
+	for _, o := range objects {
+		paths[o.Path] = o
+	}
+
+	for p, size := range map[string]int64{"café.txt": 7, "tab\there.txt": 4, "new\nline.txt": 8, "docs/with space.md": 5} {
+		if o, ok := paths[p]; !ok || o.Size != size {
+			t.Errorf("%q: got %+v, want a blob of size %d", p, o, size)
+		}
+	}
+
+	// String() should match the (quoted) output of git ls-tree line by line
+	out, err := r.Output(context.Background(), r.Command(path, "ls-tree", "-r", "--long", "HEAD"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got strings.Builder
+	for _, o := range objects {
+		got.WriteString(o.String() + "\n")
+	}
+
+	if got.String() != string(out) {
+		t.Errorf("got:\n%s\nwant:\n%s", got.String(), out)
+	}
+}
+
+func TestFixtureOptions(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newFixtureRepo)
+
+	tests := []struct {
+		name    string
+		options []lstree.Option
+		want    []string
+	}{
+		{"top level", nil, []string{"README.md", "café.txt", "docs", "new\nline.txt", "scripts", "src", "tab\there.txt", "vendor"}},
+		{"trees only", []lstree.Option{lstree.WithTreesOnly(true), lstree.WithRecurse(true)}, []string{"docs", "scripts", "src", "src/pkg", "vendor", "vendor/lib"}},
+		{"show trees", []lstree.Option{lstree.WithShowTrees(true), lstree.WithRecurse(true), lstree.WithPaths([]string{"src"})}, []string{"src", "src/main.go", "src/pkg", "src/pkg/pkg.go"}},
+		{"paths", []lstree.Option{lstree.WithRecurse(true), lstree.WithPaths([]string{"src", "vendor"})}, []string{"src/main.go", "src/pkg/pkg.go", "vendor/lib/lib.go"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, o := range listAll(t, path, "HEAD", append(test.options, lstree.WithRunner(r))...) {
+				got = append(got, o.Path)
+			}
+
+			if strings.Join(got, "|") != strings.Join(test.want, "|") {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	// from a subdirectory, only its entries are listed unless --full-tree is set
+	var got []string
+	for _, o := range listAll(t, filepath.Join(path, "src"), "HEAD", lstree.WithRunner(r), lstree.WithFullTree(true)) {
+		got = append(got, o.Path)
+	}
+	if len(got) != 8 {
+		t.Errorf("expected --full-tree to list the root of the repository, got %q", got)
+	}
+}
+
+func TestModePredicates(t *testing.T) {
+	tests := []struct {
+		mode                                         lstree.Mode
+		dir, regular, executable, symlink, submodule bool
+	}{
+		{lstree.NormalFile, false, true, false, false, false},
+		{lstree.ExecutableFile, false, true, true, false, false},
+		{"100664", false, true, false, false, false},
+		{lstree.SymbolicLink, false, false, false, true, false},
+		{lstree.Tree, true, false, false, false, false},
+		{"40000", true, false, false, false, false},
+		{lstree.Submodule, false, false, false, false, true},
+		{"bogus", false, false, false, false, false},
+	}
+
+	for _, test := range tests {
+		m := test.mode
+		if m.IsDir() != test.dir || m.IsRegular() != test.regular || m.IsExecutable() != test.executable || m.IsSymlink() != test.symlink || m.IsSubmodule() != test.submodule {
+			t.Errorf("%s: got dir=%t regular=%t executable=%t symlink=%t submodule=%t", m, m.IsDir(), m.IsRegular(), m.IsExecutable(), m.IsSymlink(), m.IsSubmodule())
+		}
+	}
+}
+
+func TestFixtureModes(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newFixtureRepo)
+
+	for _, o := range listAll(t, path, "HEAD", lstree.WithRunner(r), lstree.WithRecurse(true), lstree.WithShowTrees(true)) {
+		switch o.Path {
+		case "scripts/run.sh":
+			if o.Mode != lstree.ExecutableFile || !o.Mode.IsExecutable() || o.Type != lstree.BlobType {
+				t.Errorf("%s: expected an executable blob, got %s", o.Path, o)
+			}
+		case "src", "src/pkg":
+			if o.Mode != lstree.Tree || !o.Mode.IsDir() || o.Type != lstree.TreeType {
+				t.Errorf("%s: expected a tree, got %s", o.Path, o)
+			}
+		case "README.md":
+			if o.Mode != lstree.NormalFile || o.Mode.IsExecutable() || o.Type != lstree.BlobType {
+				t.Errorf("%s: expected a non-executable blob, got %s", o.Path, o)
+			}
+		}
+	}
+}
+
+// outputExecutor is a runner.Executor that outputs the same string for every command
+type outputExecutor string
+
+func (e outputExecutor) Start(ctx context.Context, c *runner.Command) (runner.Process, error) {
+	return outputProcess{strings.NewReader(string(e))}, nil
+}
+
+type outputProcess struct{ io.Reader }
+
+func (p outputProcess) Stdout() io.Reader { return p.Reader }
+func (p outputProcess) Stderr() string    { return "" }
+func (p outputProcess) Wait() error       { return nil }
+func (p outputProcess) Close() error      { return nil }
+
+func TestUnknownModes(t *testing.T) {
+	output := "100664 blob 8baef1b4abc478178b004d62031cf7fe6db6f903\tlegacy.txt\x00" +
+		"40000 tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\tshort-tree\x00" +
+		"100644 tag 8baef1b4abc478178b004d62031cf7fe6db6f903\tfuture.txt\x00"
+	r := runner.New(runner.WithExecutor(outputExecutor(output)))
+
+	var got []string
+	for _, o := range listAll(t, ".", "HEAD", lstree.WithRunner(r)) {
+		got = append(got, o.String()+"\x00")
+	}
+
+	if strings.Join(got, "") != output {
+		t.Errorf("got %q, want %q", got, output)
+	}
+}
+
+func newDiffRepo(t testing.TB) string {
+	long := strings.Repeat("a line long enough to be detected as a rename\n", 5)
+	dir := testrepo.New(t, []testrepo.Commit{
+		{Files: map[string]string{"a.txt": long, "b.txt": "b\n", "tab\tname.txt": "tab\n", "run.sh": "#!/bin/sh\n"}, Message: "initial commit"},
+		{Files: map[string]string{"a.txt": "", "moved/a.txt": long, "b.txt": "changed\n", "tab\tname.txt": "", "c.txt": "c\n"}, Message: "change things"},
+	})
+	testrepo.Git(t, dir, 2, "update-index", "--chmod=+x", "run.sh")
+	testrepo.Git(t, dir, 2, "commit", "--quiet", "--message", "make run.sh executable")
+	return dir
+}
+
+func TestFixtureDiff(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newDiffRepo)
+
+	diff := func(options ...lstree.Option) map[string]*lstree.Change {
+		iter, err := lstree.Diff(context.Background(), path, "HEAD~2", "HEAD", append(options, lstree.WithRunner(r))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		changes := make(map[string]*lstree.Change)
+		for {
+			c, err := iter.Next()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				t.Fatal(err)
+			}
+			changes[c.Path] = c
+		}
+		return changes
+	}
+
+	changes := diff()
+	want := map[string]lstree.ChangeType{
+		"a.txt": lstree.Deleted, "moved/a.txt": lstree.Added, "b.txt": lstree.Modified,
+		"tab\tname.txt": lstree.Deleted, "c.txt": lstree.Added, "run.sh": lstree.Modified,
+	}
+	if len(changes) != len(want) {
+		t.Errorf("got %d changes, want %d", len(changes), len(want))
+	}
+	for p, status := range want {
+		if c, ok := changes[p]; !ok || c.Status != status {
+			t.Errorf("%q: got %+v, want status %s", p, c, status)
+		}
+	}
+
+	if c := changes["run.sh"]; c == nil || c.OldMode != lstree.NormalFile || c.NewMode != lstree.ExecutableFile || c.OldHash != c.NewHash {
+		t.Errorf("expected a mode change of run.sh, got %+v", c)
+	}
+
+	if c := changes["c.txt"]; c == nil || c.OldMode != "000000" || strings.Trim(c.OldHash, "0") != "" || len(c.NewHash) != 40 {
+		t.Errorf("expected c.txt to be added, got %+v", c)
+	}
+
+	changes = diff(lstree.WithFindRenames(true))
+	if c := changes["moved/a.txt"]; c == nil || c.Status != lstree.Renamed || c.OldPath != "a.txt" || c.Similarity != 100 {
+		t.Errorf("expected a.txt to be renamed to moved/a.txt, got %+v", c)
+	}
+	if _, ok := changes["a.txt"]; ok {
+		t.Errorf("expected a.txt not to be reported as deleted when detecting renames")
+	}
+
+	changes = diff(lstree.WithPaths([]string{"b.txt"}))
+	if len(changes) != 1 || changes["b.txt"] == nil {
+		t.Errorf("expected only b.txt with a path filter, got %v", changes)
+	}
+}
+
+func newWalkRepo(t testing.TB) string {
+	return testrepo.New(t, []testrepo.Commit{
+		{Files: map[string]string{
+			"README.md":                    "# readme\n",
+			"src/main.go":                  "package main\n",
+			"src/pkg/pkg.go":               "package pkg\n",
+			"src/vendor/inner.go":          "package vendor\n",
+			"vendor/lib/lib.go":            "package lib\n",
+			"node_modules/left-pad/pad.js": "module.exports = {}\n",
+		}, Message: "initial commit"},
+	})
+}
+
+func TestFixtureWalk(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newWalkRepo)
+
+	var visited []string
+	err := lstree.Walk(context.Background(), path, "HEAD", func(o *lstree.Object) error {
+		visited = append(visited, o.Path)
+		if o.Type == lstree.TreeType && (o.Path == "vendor" || o.Path == "node_modules") {
+			return lstree.SkipSubtree
+		}
+		return nil
+	}, lstree.WithRunner(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"README.md", "node_modules", "src", "src/main.go", "src/pkg", "src/pkg/pkg.go", "src/vendor", "src/vendor/inner.go", "vendor"}
+	if strings.Join(visited, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", visited, want)
+	}
+
+	// the objects should be the same as those listed by git ls-tree
+	listed := make(map[string]string)
+	for _, o := range listAll(t, path, "HEAD^{tree}", lstree.WithRunner(r), lstree.WithRecurse(true), lstree.WithShowTrees(true)) {
+		listed[o.Path] = o.String()
+	}
+
+	err = lstree.Walk(context.Background(), path, "HEAD", func(o *lstree.Object) error {
+		if got := o.String(); got != listed[o.Path] {
+			t.Errorf("got %q, want %q", got, listed[o.Path])
+		}
+		switch o.Path {
+		case "vendor", "node_modules":
+			return lstree.SkipSubtree
+		case "src/vendor/inner.go":
+			return lstree.SkipAll
+		}
+		return nil
+	}, lstree.WithRunner(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errStop := errors.New("stop")
+	err = lstree.Walk(context.Background(), path, "HEAD", func(o *lstree.Object) error {
+		return errStop
+	}, lstree.WithRunner(r))
+	if !errors.Is(err, errStop) {
+		t.Errorf("expected the error returned by the visitor, got %v", err)
+	}
+}
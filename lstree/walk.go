@@ -0,0 +1,210 @@
+package lstree
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mergestat/gitutils/runner"
+)
+
+var (
+	// SkipSubtree can be returned by a WalkFunc called with a tree, to not walk the entries of that tree
+	SkipSubtree = errors.New("skip this subtree")
+	// SkipAll can be returned by a WalkFunc to stop walking, without Walk returning an error
+	SkipAll = errors.New("skip everything and stop the walk")
+)
+
+// WalkFunc is called by Walk for each entry of the tree. Returning SkipSubtree for a tree skips its
+// entries, returning SkipAll stops the walk, and any other error stops the walk and is returned by Walk.
+type WalkFunc func(o *Object) error
+
+// requestQueue is the standard input of git cat-file --batch, which Walk writes requests
+// to as it goes. Writes never block, so that it also works when git doesn't read them.
+type requestQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newRequestQueue() *requestQueue {
+	q := &requestQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *requestQueue) Read(p []byte) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.buf.Len() == 0 && !q.closed {
+		q.cond.Wait()
+	}
+
+	if q.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+
+	return q.buf.Read(p)
+}
+
+func (q *requestQueue) request(object string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.buf.WriteString(object + "\n")
+	q.cond.Signal()
+}
+
+// Close makes git see the end of its input, it must be called before the git process is closed or waited for
+func (q *requestQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.cond.Signal()
+	return nil
+}
+
+// walker requests trees from a long-lived `git cat-file --batch` process
+type walker struct {
+	queue  *requestQueue
+	reader *bufio.Reader
+	fn     WalkFunc
+}
+
+// readTree requests a tree and returns its raw contents, and the length of its hash in bytes
+func (w *walker) readTree(tree string) ([]byte, int, error) {
+	w.queue.request(tree)
+
+	header, err := w.reader.ReadString('\n')
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = fmt.Errorf("unexpected end of git cat-file output: %w", io.ErrUnexpectedEOF)
+		}
+		return nil, 0, err
+	}
+
+	// the header looks like "<oid> <type> <size>", or "<object> missing"
+	fields := strings.Fields(header)
+	if len(fields) != 3 || fields[1] != string(TreeType) {
+		return nil, 0, fmt.Errorf("could not read tree %s: %s", tree, strings.TrimSpace(header))
+	}
+
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, 0, fmt.Errorf("unexpected git cat-file output: %q", header)
+	}
+
+	// the contents are followed by a newline
+	contents := make([]byte, size+1)
+	if _, err := io.ReadFull(w.reader, contents); err != nil {
+		return nil, 0, err
+	}
+
+	return contents[:size], len(fields[0]) / 2, nil
+}
+
+// walk calls w.fn for each entry of a tree, recursing into the subtrees it doesn't skip
+func (w *walker) walk(tree, prefix string) error {
+	contents, hashLen, err := w.readTree(tree)
+	if err != nil {
+		return err
+	}
+
+	// each entry is "<mode> <name>\x00<binary hash>"
+	for len(contents) > 0 {
+		space := bytes.IndexByte(contents, ' ')
+		nul := bytes.IndexByte(contents, 0)
+		if space < 0 || nul < space || len(contents) < nul+1+hashLen {
+			return fmt.Errorf("could not parse tree %s", tree)
+		}
+
+		o := &Object{
+			Mode: treeEntryMode(string(contents[:space])),
+			Hash: hex.EncodeToString(contents[nul+1 : nul+1+hashLen]),
+			Path: prefix + string(contents[space+1:nul]),
+		}
+		contents = contents[nul+1+hashLen:]
+
+		switch {
+		case o.Mode.IsDir():
+			o.Type = TreeType
+		case o.Mode.IsSubmodule():
+			o.Type = CommitType
+		default:
+			o.Type = BlobType
+		}
+
+		if err := w.fn(o); err != nil {
+			if errors.Is(err, SkipSubtree) {
+				continue
+			}
+			return err
+		}
+
+		if o.Type == TreeType {
+			if err := w.walk(o.Hash, o.Path+"/"); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// treeEntryMode returns the mode of a tree entry as git ls-tree prints it, since tree objects store
+// the mode of trees without the leading zero
+func treeEntryMode(mode string) Mode {
+	if len(mode) < 6 {
+		mode = strings.Repeat("0", 6-len(mode)) + mode
+	}
+	return Mode(mode)
+}
+
+// Walk calls fn for each entry of a tree-ish, depth-first and in the order git stores them, with paths
+// relative to the root of the tree. Trees are only read when Walk descends into them, so subtrees fn skips
+// with SkipSubtree are never read. All trees are read from a single `git cat-file --batch` process.
+// Objects have no Size. Only WithRunner applies.
+// See here: https://git-scm.com/docs/git-cat-file#_batch_output
+func Walk(ctx context.Context, repoPath, treeish string, fn WalkFunc, options ...Option) error {
+	o := &execOptions{}
+	for _, option := range options {
+		option(o)
+	}
+
+	r := o.Runner
+	if r == nil {
+		r = runner.New()
+	}
+
+	queue := newRequestQueue()
+	c := r.Command(repoPath, "cat-file", "--batch")
+	c.Stdin = queue
+
+	proc, err := r.Start(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	w := &walker{queue: queue, reader: bufio.NewReader(proc.Stdout()), fn: fn}
+	if err := w.walk(treeish+"^{tree}", ""); err != nil {
+		_ = queue.Close()
+		_ = proc.Close()
+		if errors.Is(err, SkipAll) {
+			return nil
+		}
+		return err
+	}
+
+	_ = queue.Close()
+	return proc.Wait()
+}
@@ -0,0 +1,259 @@
+// Package mailmap maps the names and emails of authors and committers to their canonical identities,
+// following the rules of git's .mailmap files https://git-scm.com/docs/gitmailmap
+package mailmap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mergestat/gitutils/runner"
+)
+
+// Mailmap maps identities to canonical ones. The zero value is an empty Mailmap.
+type Mailmap struct {
+	// entries is keyed by lowercased commit email
+	entries map[string]*entry
+}
+
+// entry is the mapping of a commit email, optionally depending on the commit name
+type entry struct {
+	name, email string
+	// names is keyed by lowercased commit name
+	names map[string]*entry
+}
+
+// Parse reads a mailmap in the .mailmap file format, which has one mapping per line in one of these forms:
+//
+//	Proper Name <commit@email.xx>
+//	<proper@email.xx> <commit@email.xx>
+//	Proper Name <proper@email.xx> <commit@email.xx>
+//	Proper Name <proper@email.xx> Commit Name <commit@email.xx>
+func Parse(r io.Reader) (*Mailmap, error) {
+	m := &Mailmap{}
+	if err := m.read(r); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// read adds the mappings read from r to m. Later mappings of the same identity override earlier ones.
+func (m *Mailmap) read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// like git, only lines starting with # are comments, anything after the last email of a line is ignored anyway
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		properName, properEmail, rest, ok := parseNameEmail(line)
+		if !ok {
+			continue
+		}
+
+		commitName, commitEmail, _, ok := parseNameEmail(rest)
+		if !ok {
+			// "Proper Name <commit@email.xx>" only maps the name
+			m.add(properName, "", "", properEmail)
+			continue
+		}
+
+		m.add(properName, properEmail, commitName, commitEmail)
+	}
+
+	return scanner.Err()
+}
+
+// parseNameEmail parses an optional name followed by an email in angle brackets, and returns the rest of s
+func parseNameEmail(s string) (name, email, rest string, ok bool) {
+	start := strings.IndexByte(s, '<')
+	if start < 0 {
+		return "", "", "", false
+	}
+
+	end := strings.IndexByte(s[start:], '>')
+	if end < 0 {
+		return "", "", "", false
+	}
+	end += start
+
+	return strings.TrimSpace(s[:start]), s[start+1 : end], s[end+1:], true
+}
+
+// add maps commitEmail, and commitName if it's not empty, to properName and properEmail
+func (m *Mailmap) add(properName, properEmail, commitName, commitEmail string) {
+	if m.entries == nil {
+		m.entries = make(map[string]*entry)
+	}
+
+	key := strings.ToLower(commitEmail)
+	e, ok := m.entries[key]
+	if !ok {
+		e = &entry{}
+		m.entries[key] = e
+	}
+
+	if commitName != "" {
+		if e.names == nil {
+			e.names = make(map[string]*entry)
+		}
+		nameKey := strings.ToLower(commitName)
+		if _, ok := e.names[nameKey]; !ok {
+			e.names[nameKey] = &entry{}
+		}
+		e = e.names[nameKey]
+	}
+
+	if properName != "" {
+		e.name = properName
+	}
+	if properEmail != "" {
+		e.email = properEmail
+	}
+}
+
+// Resolve returns the canonical name and email of an identity. Parts that aren't mapped are returned as they are.
+// Emails and names are matched case-insensitively.
+func (m *Mailmap) Resolve(name, email string) (string, string) {
+	if m == nil {
+		return name, email
+	}
+
+	e, ok := m.entries[strings.ToLower(email)]
+	if !ok {
+		return name, email
+	}
+
+	if byName, ok := e.names[strings.ToLower(name)]; ok {
+		e = byName
+	}
+
+	if e.name != "" {
+		name = e.name
+	}
+	if e.email != "" {
+		email = e.email
+	}
+
+	return name, email
+}
+
+// Len returns the number of commit emails with a mapping
+func (m *Mailmap) Len() int {
+	if m == nil {
+		return 0
+	}
+	return len(m.entries)
+}
+
+type Option func(o *execOptions)
+
+type execOptions struct {
+	Runner *runner.Runner
+}
+
+// WithRunner sets the *runner.Runner used to invoke git
+func WithRunner(r *runner.Runner) Option {
+	return func(o *execOptions) {
+		o.Runner = r
+	}
+}
+
+// Load reads the mailmap of a repository the same way git does: the .mailmap file at the root
+// of the working tree, then the blob named by the mailmap.blob config (which defaults to
+// HEAD:.mailmap in bare repositories), then the file named by the mailmap.file config.
+// Missing files and blobs are ignored.
+func Load(ctx context.Context, repoPath string, options ...Option) (*Mailmap, error) {
+	o := &execOptions{}
+	for _, option := range options {
+		option(o)
+	}
+
+	r := o.Runner
+	if r == nil {
+		r = runner.New()
+	}
+
+	m := &Mailmap{}
+
+	out, err := r.Output(ctx, r.Command(repoPath, "rev-parse", "--is-bare-repository"))
+	if err != nil {
+		return nil, err
+	}
+	bare := strings.TrimSpace(string(out)) == "true"
+
+	if !bare {
+		out, err := r.Output(ctx, r.Command(repoPath, "rev-parse", "--show-toplevel"))
+		if err != nil {
+			return nil, err
+		}
+		if err := m.readFile(filepath.Join(strings.TrimSpace(string(out)), ".mailmap")); err != nil {
+			return nil, err
+		}
+	}
+
+	blob, err := config(ctx, r, repoPath, "--get", "mailmap.blob")
+	if err != nil {
+		return nil, err
+	}
+	if blob == "" && bare {
+		blob = "HEAD:.mailmap"
+	}
+	if blob != "" {
+		out, err := r.Output(ctx, r.Command(repoPath, "cat-file", "blob", blob))
+		var exitErr *runner.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			return nil, err
+		}
+		// like git, ignore a blob that doesn't exist
+		if err == nil {
+			if err := m.read(bytes.NewReader(out)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	file, err := config(ctx, r, repoPath, "--type=path", "--get", "mailmap.file")
+	if err != nil {
+		return nil, err
+	}
+	if file != "" {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(repoPath, file)
+		}
+		if err := m.readFile(file); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// config runs git config with the given args, and returns the value or an empty string if it's not set
+func config(ctx context.Context, r *runner.Runner, repoPath string, args ...string) (string, error) {
+	out, err := r.Output(ctx, r.Command(repoPath, append([]string{"config", "--default", ""}, args...)...))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// readFile adds the mappings of the mailmap file at path to m, ignoring a missing file
+func (m *Mailmap) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	return m.read(f)
+}
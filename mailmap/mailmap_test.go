@@ -0,0 +1,81 @@
+package mailmap_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mergestat/gitutils/internal/testrepo"
+	"github.com/mergestat/gitutils/mailmap"
+)
+
+const fixtureMailmap = `# comments and blank lines are ignored
+
+Jane Doe <jane@example.com>
+<jane@example.com> <jane@old.example>
+Joe Proper <joe@example.com> <JOE@laptop.local> # trailing comment
+Team Bot <bot@example.com> ci <shared@example.com>
+Other Person <other@example.com> other <shared@example.com>
+C# Team <csharp@example.com> <dotnet@example.com>
+#Commented Out <commented@example.com> <jane@example.com>
+not a mapping
+`
+
+func TestResolve(t *testing.T) {
+	m, err := mailmap.Parse(strings.NewReader(fixtureMailmap))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, email         string
+		wantName, wantEmail string
+	}{
+		{"jane", "jane@example.com", "Jane Doe", "jane@example.com"},
+		{"jane", "Jane@Example.com", "Jane Doe", "Jane@Example.com"},
+		{"jane", "jane@old.example", "jane", "jane@example.com"},
+		{"joe", "joe@laptop.local", "Joe Proper", "joe@example.com"},
+		{"CI", "shared@example.com", "Team Bot", "bot@example.com"},
+		{"other", "shared@example.com", "Other Person", "other@example.com"},
+		{"someone", "shared@example.com", "someone", "shared@example.com"},
+		{"dotnet", "dotnet@example.com", "C# Team", "csharp@example.com"},
+		{"unmapped", "unmapped@example.com", "unmapped", "unmapped@example.com"},
+	}
+
+	for _, test := range tests {
+		name, email := m.Resolve(test.name, test.email)
+		if name != test.wantName || email != test.wantEmail {
+			t.Errorf("%s <%s>: got %s <%s>, want %s <%s>", test.name, test.email, name, email, test.wantName, test.wantEmail)
+		}
+	}
+
+	if m.Len() != 5 {
+		t.Errorf("got %d mapped emails, want 5", m.Len())
+	}
+
+	var empty *mailmap.Mailmap
+	if name, email := empty.Resolve("a", "a@example.com"); name != "a" || email != "a@example.com" {
+		t.Errorf("expected a nil mailmap to map nothing, got %s <%s>", name, email)
+	}
+}
+
+func newFixtureRepo(t testing.TB) string {
+	dir := testrepo.New(t, []testrepo.Commit{
+		{Files: map[string]string{".mailmap": "Canonical Author <canonical@example.com> <author@example.com>\n"}, Message: "add mailmap"},
+	})
+	testrepo.Git(t, dir, 1, "config", "mailmap.blob", "HEAD:.mailmap")
+	return dir
+}
+
+func TestFixtureLoad(t *testing.T) {
+	r, path := testrepo.Fixtures(t, newFixtureRepo)
+
+	m, err := mailmap.Load(context.Background(), path, mailmap.WithRunner(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name, email := m.Resolve("Fixture Author", "author@example.com"); name != "Canonical Author" || email != "canonical@example.com" {
+		t.Errorf("got %s <%s>, want Canonical Author <canonical@example.com>", name, email)
+	}
+}
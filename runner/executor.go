@@ -0,0 +1,159 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// maxStderr is the most stderr output we'll hold on to for a single git process
+const maxStderr = 64 * 1024
+
+// Executor starts git processes. Every package in gitutils starts git through an Executor,
+// which makes it possible to substitute recorded output for a real git binary (see Recorder and Replayer).
+type Executor interface {
+	Start(ctx context.Context, c *Command) (Process, error)
+}
+
+// Process is a started git process
+type Process interface {
+	// Stdout returns the standard output of the process
+	Stdout() io.Reader
+	// Stderr returns what the process wrote to stderr (possibly truncated).
+	// It must only be called after Wait has returned.
+	Stderr() string
+	// Wait waits for the process to exit, after Stdout has been read to completion.
+	// A non-zero exit is reported as an *ExitError. Wait may be called more than once.
+	Wait() error
+	// Close stops the process if it's still running and releases its resources.
+	// It's safe to call Close more than once, and after Wait.
+	Close() error
+}
+
+// execExecutor is the default Executor, it runs the git binary using the os/exec package
+type execExecutor struct {
+	gitPath string
+}
+
+// NewExecExecutor returns the default Executor, which runs the git binary at gitPath using the os/exec package.
+// If gitPath is empty, git is looked up on $PATH.
+func NewExecExecutor(gitPath string) Executor {
+	return &execExecutor{gitPath: gitPath}
+}
+
+// path returns the git binary to use
+func (e *execExecutor) path() (string, error) {
+	if e.gitPath != "" {
+		return e.gitPath, nil
+	}
+
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		return "", fmt.Errorf("could not find git: %w", err)
+	}
+
+	return gitPath, nil
+}
+
+func (e *execExecutor) Start(ctx context.Context, c *Command) (Process, error) {
+	gitPath, err := e.path()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, gitPath, c.Args...)
+	cmd.Dir = c.Dir
+	cmd.Env = c.Env
+	cmd.Stdin = c.Stdin
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &execProcess{cmd: cmd, args: c.Args, stdout: stdout}
+	cmd.Stderr = &p.stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// execProcess is a git process started with the os/exec package
+type execProcess struct {
+	cmd    *exec.Cmd
+	args   []string
+	stdout io.Reader
+	stderr limitedBuffer
+	done   bool
+	err    error
+}
+
+func (p *execProcess) Stdout() io.Reader {
+	return p.stdout
+}
+
+func (p *execProcess) Stderr() string {
+	return p.stderr.String()
+}
+
+func (p *execProcess) Wait() error {
+	if p.done {
+		return p.err
+	}
+	p.done = true
+
+	if err := p.cmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitErr.Stderr = p.stderr.Bytes()
+			p.err = &ExitError{
+				ExitCode: exitErr.ExitCode(),
+				Args:     p.args,
+				Stderr:   p.stderr.String(),
+				Err:      err,
+			}
+		} else {
+			p.err = err
+		}
+	}
+
+	return p.err
+}
+
+func (p *execProcess) Close() error {
+	if p.done {
+		return nil
+	}
+	p.done = true
+
+	if p.cmd.Process != nil {
+		// the process may have exited on its own already, which is fine
+		_ = p.cmd.Process.Kill()
+	}
+
+	// the error from Wait() is expected here, since we (probably) just killed the process
+	_ = p.cmd.Wait()
+
+	return nil
+}
+
+// limitedBuffer is a bytes.Buffer that silently drops anything written past maxStderr
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := maxStderr - b.Len(); remaining < len(p) {
+		if remaining > 0 {
+			b.Buffer.Write(p[:remaining])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,257 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// fixture is the metadata of a recorded git run. Stdout is stored alongside it, in a separate file.
+type fixture struct {
+	Args     []string `json:"args"`
+	Stderr   string   `json:"stderr"`
+	ExitCode int      `json:"exitCode"`
+}
+
+// fixtureName returns the base name of the fixture files for a git invocation.
+// Fixtures are keyed by the arguments and the standard input (if it's buffered, see bufferedStdin),
+// so recordings are portable between machines (the working directory and environment are not considered).
+func fixtureName(args []string, stdin []byte) string {
+	key := strings.Join(args, "\x00")
+	if len(stdin) > 0 {
+		key += "\x00\x00" + string(stdin)
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// bufferedStdin reads the standard input of c if it's held in memory (a *bytes.Reader, *strings.Reader or
+// *bytes.Buffer), and returns it along with a copy of c that reads from it. Other readers are left alone, as they
+// can be streams that only end once git has answered them, such as the requests lstree.Walk writes to git cat-file.
+func bufferedStdin(c *Command) (*Command, []byte, error) {
+	switch c.Stdin.(type) {
+	case *bytes.Reader, *strings.Reader, *bytes.Buffer:
+	default:
+		return c, nil, nil
+	}
+
+	stdin, err := io.ReadAll(c.Stdin)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	buffered := *c
+	buffered.Stdin = bytes.NewReader(stdin)
+	return &buffered, stdin, nil
+}
+
+// recorded holds the stdout and exit code of the fixtures saved by every Recorder in this process, by file name.
+// Fixtures left on disk by earlier runs are overwritten, as they're usually recorded from a different repo.
+var recorded = struct {
+	sync.Mutex
+	fixtures map[string]recordedFixture
+}{fixtures: make(map[string]recordedFixture)}
+
+type recordedFixture struct {
+	stdout   string
+	exitCode int
+}
+
+// Recorder is an Executor that runs git using another Executor, and saves the stdout, stderr
+// and exit code of every process that runs to completion as fixture files in Dir.
+// Processes that are closed early are not recorded.
+//
+// Fixtures are keyed by the arguments, and by the standard input when it's held in memory (see bufferedStdin).
+// Streamed input, the working directory and the environment aren't part of the key, so commands that only
+// differ by those share a fixture: recording them with a different stdout or exit code is an error,
+// rather than the second recording silently replacing the first.
+type Recorder struct {
+	Executor Executor
+	Dir      string
+}
+
+// NewRecorder returns a *Recorder that writes fixtures to dir, recording the processes started by executor.
+// If executor is nil, git is looked up on $PATH and run using the os/exec package.
+func NewRecorder(dir string, executor Executor) *Recorder {
+	if executor == nil {
+		executor = NewExecExecutor("")
+	}
+	return &Recorder{Executor: executor, Dir: dir}
+}
+
+func (r *Recorder) Start(ctx context.Context, c *Command) (Process, error) {
+	c, stdin, err := bufferedStdin(c)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := r.Executor.Start(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	rp := &recordingProcess{Process: p, recorder: r, args: c.Args, name: fixtureName(c.Args, stdin)}
+	rp.stdout = io.TeeReader(p.Stdout(), &rp.buf)
+
+	return rp, nil
+}
+
+// save writes out the fixture files for a completed process
+func (r *Recorder) save(name string, args []string, stdout []byte, stderr string, exitCode int) error {
+	if err := os.MkdirAll(r.Dir, 0o755); err != nil {
+		return err
+	}
+
+	meta, err := json.MarshalIndent(&fixture{Args: args, Stderr: stderr, ExitCode: exitCode}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	name = filepath.Join(r.Dir, name)
+
+	recorded.Lock()
+	defer recorded.Unlock()
+	if prev, ok := recorded.fixtures[name]; ok && (prev.stdout != string(stdout) || prev.exitCode != exitCode) {
+		return fmt.Errorf("git %s was already recorded to %s with a different result", strings.Join(args, " "), name)
+	}
+	recorded.fixtures[name] = recordedFixture{stdout: string(stdout), exitCode: exitCode}
+
+	if err := os.WriteFile(name+".stdout", stdout, 0o644); err != nil {
+		return err
+	}
+
+	return os.WriteFile(name+".json", append(meta, '\n'), 0o644)
+}
+
+type recordingProcess struct {
+	Process
+	recorder *Recorder
+	args     []string
+	name     string
+	buf      bytes.Buffer
+	stdout   io.Reader
+	once     sync.Once
+	err      error
+}
+
+func (p *recordingProcess) Stdout() io.Reader {
+	return p.stdout
+}
+
+func (p *recordingProcess) Wait() error {
+	err := p.Process.Wait()
+
+	p.once.Do(func() {
+		var exitCode int
+		var exitErr *ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode
+		} else if err != nil {
+			return // the process didn't run to completion, there's nothing to record
+		}
+
+		if saveErr := p.recorder.save(p.name, p.args, p.buf.Bytes(), p.Process.Stderr(), exitCode); saveErr != nil {
+			p.err = fmt.Errorf("could not record fixture: %w", saveErr)
+		}
+	})
+
+	if p.err != nil {
+		return p.err
+	}
+
+	return err
+}
+
+// Replayer is an Executor that never runs git. Instead, it replays the fixtures saved by a Recorder,
+// which are looked up in the same way they're keyed. Starting a command that has no fixture in Dir returns an error.
+type Replayer struct {
+	Dir string
+}
+
+// NewReplayer returns a *Replayer that reads fixtures from dir
+func NewReplayer(dir string) *Replayer {
+	return &Replayer{Dir: dir}
+}
+
+func (r *Replayer) Start(ctx context.Context, c *Command) (Process, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c, stdin, err := bufferedStdin(c)
+	if err != nil {
+		return nil, err
+	}
+
+	name := filepath.Join(r.Dir, fixtureName(c.Args, stdin))
+
+	meta, err := os.ReadFile(name + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("no fixture for git %s: %w", strings.Join(c.Args, " "), err)
+	}
+
+	var f fixture
+	if err := json.Unmarshal(meta, &f); err != nil {
+		return nil, fmt.Errorf("could not parse fixture %s.json: %w", name, err)
+	}
+
+	stdout, err := os.ReadFile(name + ".stdout")
+	if err != nil {
+		return nil, err
+	}
+
+	return &replayProcess{fixture: f, stdout: bytes.NewReader(stdout)}, nil
+}
+
+type replayProcess struct {
+	fixture
+	stdout io.Reader
+}
+
+func (p *replayProcess) Stdout() io.Reader {
+	return p.stdout
+}
+
+func (p *replayProcess) Stderr() string {
+	return p.fixture.Stderr
+}
+
+func (p *replayProcess) Wait() error {
+	if p.ExitCode == 0 {
+		return nil
+	}
+
+	return &ExitError{
+		ExitCode: p.ExitCode,
+		Args:     p.Args,
+		Stderr:   p.fixture.Stderr,
+		Err:      &replayedExitError{code: p.ExitCode, err: &exec.ExitError{Stderr: []byte(p.fixture.Stderr)}},
+	}
+}
+
+// replayedExitError reports the recorded exit status of a replayed process like os/exec does, and wraps an
+// *exec.ExitError so that errors.As finds one, as it does for git processes that actually ran. As no process ran,
+// that *exec.ExitError has no ProcessState (its ExitCode() is -1 and it prints as "<nil>"), so use the exit code
+// and stderr of the *ExitError wrapping this error instead.
+type replayedExitError struct {
+	code int
+	err  *exec.ExitError
+}
+
+func (e *replayedExitError) Error() string { return fmt.Sprintf("exit status %d", e.code) }
+
+func (e *replayedExitError) Unwrap() error { return e.err }
+
+func (p *replayProcess) Close() error {
+	return nil
+}
@@ -0,0 +1,172 @@
+// Package runner provides the shared way every package in gitutils invokes the git binary.
+// A *Runner pins the git binary, the base environment, global `-c key=value` config
+// and any extra global flags, so they can be set once and applied across the whole library.
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// ConfigKV is a single git config key/value pair, passed as `-c <key>=<value>`
+type ConfigKV struct {
+	Key   string
+	Value string
+}
+
+// Runner holds the settings shared by every git invocation made through it.
+// The zero value is not usable, construct one with New().
+type Runner struct {
+	gitPath     string
+	env         []string
+	config      []ConfigKV
+	dir         string
+	globalFlags []string
+	executor    Executor
+}
+
+type Option func(r *Runner)
+
+// WithGitPath pins the git binary to use. If not set, git is looked up on $PATH.
+func WithGitPath(gitPath string) Option {
+	return func(r *Runner) {
+		r.gitPath = gitPath
+	}
+}
+
+// WithEnv sets the base environment for git processes, in the same form as os.Environ().
+// If not set, git inherits the environment of the current process.
+// For example, []string{"HOME=/tmp/empty", "GIT_CONFIG_NOSYSTEM=1"} isolates git from user and system config.
+func WithEnv(env []string) Option {
+	return func(r *Runner) {
+		r.env = env
+	}
+}
+
+// WithConfig sets the `-c <key>=<value>` global option for every key/value pair
+func WithConfig(config []ConfigKV) Option {
+	return func(r *Runner) {
+		r.config = config
+	}
+}
+
+// WithDir sets the working directory git runs in. Relative repository paths
+// passed to a package's Exec are resolved against it.
+func WithDir(dir string) Option {
+	return func(r *Runner) {
+		r.dir = dir
+	}
+}
+
+// WithGlobalFlags sets flags placed before the git subcommand, such as --no-optional-locks
+func WithGlobalFlags(globalFlags []string) Option {
+	return func(r *Runner) {
+		r.globalFlags = globalFlags
+	}
+}
+
+// WithExecutor sets the Executor used to start git processes, for instance a *Replayer in tests.
+// If not set, the git binary is run using the os/exec package. Note that WithGitPath has no effect
+// when an Executor is supplied, use NewExecExecutor to pin the binary instead.
+func WithExecutor(executor Executor) Option {
+	return func(r *Runner) {
+		r.executor = executor
+	}
+}
+
+// New returns a *Runner configured with the supplied options
+func New(options ...Option) *Runner {
+	r := &Runner{}
+	for _, option := range options {
+		option(r)
+	}
+	if r.executor == nil {
+		r.executor = &execExecutor{gitPath: r.gitPath}
+	}
+	return r
+}
+
+// Command describes a single invocation of git
+type Command struct {
+	// Args are the arguments passed to git, including global flags and config
+	Args []string
+	// Dir is the working directory of the git process
+	Dir string
+	// Env is the environment of the git process, nil means inherit the current one
+	Env []string
+	// Stdin is an optional reader connected to the standard input of the git process
+	Stdin io.Reader
+}
+
+// Command builds a *Command that runs the git subcommand described by args in dir,
+// with the global config and flags of the runner applied.
+func (r *Runner) Command(dir string, args ...string) *Command {
+	full := make([]string, 0, len(r.globalFlags)+2*len(r.config)+len(args))
+	full = append(full, r.globalFlags...)
+	for _, pair := range r.config {
+		full = append(full, "-c", fmt.Sprintf("%s=%s", pair.Key, pair.Value))
+	}
+	full = append(full, args...)
+
+	switch {
+	case dir == "":
+		dir = r.dir
+	case !filepath.IsAbs(dir) && r.dir != "":
+		dir = filepath.Join(r.dir, dir)
+	}
+
+	return &Command{
+		Args: full,
+		Dir:  dir,
+		Env:  r.env,
+	}
+}
+
+// Start starts the git process described by c, with its stdout available from the returned Process.
+// If the process exits with a non-zero status, Wait() returns an *ExitError.
+func (r *Runner) Start(ctx context.Context, c *Command) (Process, error) {
+	return r.executor.Start(ctx, c)
+}
+
+// Output runs the git process described by c to completion and returns its stdout
+func (r *Runner) Output(ctx context.Context, c *Command) ([]byte, error) {
+	p, err := r.Start(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := io.ReadAll(p.Stdout())
+	if err != nil {
+		_ = p.Close()
+		return nil, err
+	}
+
+	if err := p.Wait(); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// ExitError is returned when the git process exits with a non-zero status.
+// It carries the exit code, the arguments git was invoked with and whatever
+// was written to stderr, so callers don't have to go digging for it.
+type ExitError struct {
+	ExitCode int
+	Args     []string
+	Stderr   string
+	Err      error
+}
+
+func (e *ExitError) Error() string {
+	msg := strings.TrimSpace(e.Stderr)
+	if msg == "" {
+		msg = e.Err.Error()
+	}
+	return fmt.Sprintf("git %s: exit status %d: %s", strings.Join(e.Args, " "), e.ExitCode, msg)
+}
+
+func (e *ExitError) Unwrap() error { return e.Err }
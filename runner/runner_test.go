@@ -0,0 +1,200 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommandArgs(t *testing.T) {
+	r := New(
+		WithGlobalFlags([]string{"--no-optional-locks"}),
+		WithConfig([]ConfigKV{{Key: "core.quotePath", Value: "false"}, {Key: "a.b", Value: "c"}}),
+		WithDir("/some/dir"),
+		WithEnv([]string{"GIT_CONFIG_NOSYSTEM=1"}),
+	)
+
+	c := r.Command("repo", "log", "--oneline")
+
+	want := []string{"--no-optional-locks", "-c", "core.quotePath=false", "-c", "a.b=c", "log", "--oneline"}
+	if !reflect.DeepEqual(c.Args, want) {
+		t.Errorf("got %v, want %v", c.Args, want)
+	}
+
+	if c.Dir != "/some/dir/repo" {
+		t.Errorf("got dir %s, want /some/dir/repo", c.Dir)
+	}
+
+	if !reflect.DeepEqual(c.Env, []string{"GIT_CONFIG_NOSYSTEM=1"}) {
+		t.Errorf("unexpected env: %v", c.Env)
+	}
+
+	if c := r.Command("/abs/repo", "status"); c.Dir != "/abs/repo" {
+		t.Errorf("got dir %s, want /abs/repo", c.Dir)
+	}
+
+	if c := r.Command("", "status"); c.Dir != "/some/dir" {
+		t.Errorf("got dir %s, want /some/dir", c.Dir)
+	}
+}
+
+func TestOutputOK(t *testing.T) {
+	r := New(WithConfig([]ConfigKV{{Key: "gitutils.test", Value: "hello"}}))
+
+	out, err := r.Output(context.Background(), r.Command(t.TempDir(), "config", "gitutils.test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.TrimSpace(string(out)); got != "hello" {
+		t.Fatalf("got %q, want %q", got, "hello")
+	}
+}
+
+func TestExitError(t *testing.T) {
+	r := New()
+
+	_, err := r.Output(context.Background(), r.Command(t.TempDir(), "log"))
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("expected an *ExitError, got: %v", err)
+	}
+
+	if exitErr.ExitCode != 128 {
+		t.Errorf("got exit code %d, want 128", exitErr.ExitCode)
+	}
+
+	if !reflect.DeepEqual(exitErr.Args, []string{"log"}) {
+		t.Errorf("got args %v, want [log]", exitErr.Args)
+	}
+
+	contains := "not a git repository"
+	if !strings.Contains(exitErr.Stderr, contains) {
+		t.Fatalf("expected Stderr to include: \"%s\", got: %s", contains, exitErr.Stderr)
+	}
+
+	var execErr *exec.ExitError
+	if !errors.As(err, &execErr) {
+		t.Fatalf("expected the error to wrap an *exec.ExitError")
+	}
+}
+
+func TestGitPath(t *testing.T) {
+	r := New(WithGitPath("/does/not/exist/git"))
+
+	if _, err := r.Output(context.Background(), r.Command("", "version")); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	fixtures := t.TempDir()
+	repo := t.TempDir()
+
+	rec := New(WithExecutor(NewRecorder(fixtures, nil)))
+
+	if _, err := rec.Output(context.Background(), rec.Command(repo, "init", "--quiet")); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := rec.Output(context.Background(), rec.Command(repo, "-c", "gitutils.test=hello", "config", "gitutils.test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, wantErr := rec.Output(context.Background(), rec.Command(repo, "rev-parse", "--verify", "HEAD"))
+	if wantErr == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	// in-memory stdin is part of the key of fixtures
+	hashes := make(map[string]string)
+	for _, stdin := range []string{"one", "two"} {
+		c := rec.Command(repo, "hash-object", "--stdin")
+		c.Stdin = strings.NewReader(stdin)
+		out, err := rec.Output(context.Background(), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes[stdin] = string(out)
+	}
+
+	// the replayer never runs git, so the working directory doesn't matter
+	rep := New(WithExecutor(NewReplayer(fixtures)))
+
+	for stdin, want := range hashes {
+		c := rep.Command("does-not-exist", "hash-object", "--stdin")
+		c.Stdin = strings.NewReader(stdin)
+		if got, err := rep.Output(context.Background(), c); err != nil || string(got) != want {
+			t.Errorf("got %q (%v) replaying hash-object of %q, want %q", got, err, stdin, want)
+		}
+	}
+
+	got, err := rep.Output(context.Background(), rep.Command("does-not-exist", "-c", "gitutils.test=hello", "config", "gitutils.test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != string(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	_, gotErr := rep.Output(context.Background(), rep.Command("does-not-exist", "rev-parse", "--verify", "HEAD"))
+
+	var exitErr *ExitError
+	if !errors.As(gotErr, &exitErr) {
+		t.Fatalf("expected an *ExitError, got: %v", gotErr)
+	}
+
+	if gotErr.Error() != wantErr.Error() {
+		t.Fatalf("got error %q, want %q", gotErr, wantErr)
+	}
+
+	var wantExitErr *ExitError
+	if !errors.As(wantErr, &wantExitErr) || exitErr.ExitCode != wantExitErr.ExitCode {
+		t.Fatalf("got exit code %d, want %d", exitErr.ExitCode, wantExitErr.ExitCode)
+	}
+
+	var execErr *exec.ExitError
+	if !errors.As(gotErr, &execErr) || string(execErr.Stderr) != exitErr.Stderr {
+		t.Fatalf("expected the replayed error to wrap an *exec.ExitError with stderr, got: %v", gotErr)
+	}
+
+	if _, err := rep.Output(context.Background(), rep.Command("", "status")); err == nil {
+		t.Fatal("expected an error replaying a command with no fixture")
+	}
+}
+
+func TestRecordConflict(t *testing.T) {
+	fixtures := t.TempDir()
+	rec := New(WithExecutor(NewRecorder(fixtures, nil)))
+
+	repos := []string{t.TempDir(), t.TempDir()}
+	for n, repo := range repos {
+		if _, err := rec.Output(context.Background(), rec.Command(repo, "init", "--quiet")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rec.Output(context.Background(), rec.Command(repo, "config", "gitutils.test", fmt.Sprint(n))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// recording the same args again with the same result is fine
+	for i := 0; i < 2; i++ {
+		if _, err := rec.Output(context.Background(), rec.Command(repos[0], "config", "gitutils.test")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := rec.Output(context.Background(), rec.Command(repos[1], "config", "gitutils.test")); err == nil {
+		t.Fatal("expected an error recording the same args with a different output")
+	}
+}